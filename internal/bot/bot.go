@@ -78,13 +78,17 @@ func New(logger *slog.Logger, cfg *config.RobotoConfig) (*RobotoBot, error) {
 				gateway.IntentGuildVoiceStates,
 				gateway.IntentGuildMessages,
 				gateway.IntentMessageContent,
-				// gateway.IntentsDirectMessage,
+				gateway.IntentDirectMessages,
 			),
 		),
 		bot.WithCacheConfigOpts(
 			cache.WithCaches(
 				cache.FlagMessages,
 				cache.FlagGuilds,
+				cache.FlagChannels,
+				cache.FlagStageInstances,
+				cache.FlagRoles,
+				cache.FlagMembers,
 				cache.FlagVoiceStates,
 			),
 		),
@@ -96,13 +100,22 @@ func New(logger *slog.Logger, cfg *config.RobotoConfig) (*RobotoBot, error) {
 	roboto.Discord = discord
 	if cfg.Lavalink != nil {
 		logger.Info("lavalink integrations enabled")
-		roboto.Player = player.New(discord, cfg.Lavalink)
+		roboto.Player, err = player.New(discord, cfg.Lavalink, cfg.Storage)
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		logger.Info("lavalink integrations disabled")
 	}
 	if cfg.Ollama != nil {
 		logger.Info("ollama integrations enabled")
-		roboto.Ollama = ollama.New(discord, cfg.Ollama)
+		roboto.Ollama, err = ollama.New(discord, cfg.Ollama, cfg.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if roboto.Player != nil {
+			roboto.Ollama.Tools.Register(musicTools(roboto.Player)...)
+		}
 	} else {
 		logger.Info("ollama integrations disabled")
 	}
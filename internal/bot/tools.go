@@ -0,0 +1,290 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Akvanvig/roboto-go/internal/ollama"
+	"github.com/Akvanvig/roboto-go/internal/player"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgolink/v3/lavalink"
+)
+
+// Resolves the member asking the model for something, with their permissions in the chat channel
+func toolMember(tc ollama.ToolContext) (discord.ResolvedMember, error) {
+	if tc.GuildID == nil {
+		return discord.ResolvedMember{}, fmt.Errorf("music can only be controlled from a server")
+	}
+
+	// NOTE:
+	// The member comes with the message, the cache only has it with the guild members intent
+	caches := tc.Client.Caches
+	var member discord.Member
+	if tc.Member != nil {
+		member = *tc.Member
+		member.User = tc.User
+	} else {
+		var ok bool
+		member, ok = caches.Member(*tc.GuildID, tc.User.ID)
+		if !ok {
+			return discord.ResolvedMember{}, fmt.Errorf("failed to look up the user asking")
+		}
+	}
+
+	channel, ok := caches.Channel(tc.ChannelID)
+	if !ok {
+		return discord.ResolvedMember{}, fmt.Errorf("failed to look up the current channel")
+	}
+
+	return discord.ResolvedMember{
+		Member:      member,
+		Permissions: caches.MemberPermissionsInChannel(channel, member),
+	}, nil
+}
+
+func toolSearch(ctx context.Context, p *player.Player, tc ollama.ToolContext, query string) ([]lavalink.Track, error) {
+	var tracks []lavalink.Track
+	var searchErr error
+
+	// NOTE:
+	// Searching completes before Search returns, so the handlers are done by then
+	err := p.Search(ctx, *tc.GuildID, p.Query(*tc.GuildID, query, ""),
+		func(results ...lavalink.Track) {
+			tracks = results
+		},
+		func(err error) {
+			searchErr = err
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return tracks, searchErr
+}
+
+func toolTracks(tracks []lavalink.Track) string {
+	var b strings.Builder
+	for i, track := range tracks {
+		fmt.Fprintf(&b, "%d. %s by %s (%d:%02d)\n", i+1, track.Info.Title, track.Info.Author, track.Info.Length.Minutes(), track.Info.Length.SecondsPart())
+	}
+	return b.String()
+}
+
+var toolQueryParameters = json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"query": {
+			"type": "string",
+			"description": "Search terms or a link to the song"
+		}
+	},
+	"required": ["query"]
+}`)
+
+// Tools letting the chat model control the music player on behalf of the message author.
+// They go through the same checks as the music commands.
+func musicTools(p *player.Player) []ollama.Tool {
+	return []ollama.Tool{
+		{
+			Name:        "music_search",
+			Description: "Search for a song without playing it",
+			Parameters:  toolQueryParameters,
+			Handler: func(ctx context.Context, tc ollama.ToolContext, args json.RawMessage) (string, error) {
+				var params struct {
+					Query string `json:"query"`
+				}
+				err := json.Unmarshal(args, &params)
+				if err != nil {
+					return "", err
+				}
+				if tc.GuildID == nil {
+					return "", fmt.Errorf("music can only be searched from a server")
+				}
+
+				tracks, err := toolSearch(ctx, p, tc, params.Query)
+				if err != nil {
+					return "", err
+				}
+				if len(tracks) == 0 {
+					return "No results found", nil
+				}
+
+				return toolTracks(tracks), nil
+			},
+		},
+		{
+			Name:        "music_play",
+			Description: "Search for a song and add it to the music queue in the voice channel of the user",
+			Parameters:  toolQueryParameters,
+			Handler: func(ctx context.Context, tc ollama.ToolContext, args json.RawMessage) (string, error) {
+				var params struct {
+					Query string `json:"query"`
+				}
+				err := json.Unmarshal(args, &params)
+				if err != nil {
+					return "", err
+				}
+				member, err := toolMember(tc)
+				if err != nil {
+					return "", err
+				}
+				err = p.CheckDJ(*tc.GuildID, member)
+				if err != nil {
+					return "", err
+				}
+
+				voiceChannelID, err := p.CheckJoin(*tc.GuildID, tc.User.ID)
+				if err != nil {
+					return "", err
+				}
+
+				textChannelID := tc.ChannelID
+				if settings := p.Settings(*tc.GuildID); settings.AnnounceChannelID != 0 {
+					textChannelID = settings.AnnounceChannelID
+				}
+
+				err = p.Preflight(*tc.GuildID, voiceChannelID, textChannelID)
+				if err != nil {
+					return "", err
+				}
+
+				tracks, err := toolSearch(ctx, p, tc, params.Query)
+				if err != nil {
+					return "", err
+				}
+				if len(tracks) == 0 {
+					return "No results found", nil
+				}
+
+				err = p.Join(ctx, *tc.GuildID, voiceChannelID)
+				if err != nil {
+					return "", err
+				}
+
+				err = p.Add(ctx, *tc.GuildID, tc.ChannelID, tc.User, tracks...)
+				if err != nil {
+					return "", err
+				}
+
+				return "Added to queue:\n" + toolTracks(tracks), nil
+			},
+		},
+		{
+			Name:        "music_skip",
+			Description: "Skip one or more songs in the music queue",
+			Parameters: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"count": {
+						"type": "integer",
+						"description": "The number of songs to skip, defaults to 1",
+						"minimum": 1
+					}
+				}
+			}`),
+			Handler: func(ctx context.Context, tc ollama.ToolContext, args json.RawMessage) (string, error) {
+				params := struct {
+					Count int `json:"count"`
+				}{
+					Count: 1,
+				}
+				if len(args) > 0 {
+					err := json.Unmarshal(args, &params)
+					if err != nil {
+						return "", err
+					}
+				}
+
+				member, err := toolMember(tc)
+				if err != nil {
+					return "", err
+				}
+				err = p.CheckControl(*tc.GuildID, tc.ChannelID, member)
+				if err != nil {
+					return "", err
+				}
+
+				track, err := p.Skip(ctx, *tc.GuildID, max(params.Count, 1))
+				if err != nil {
+					return "", err
+				}
+				if track == nil {
+					return "The queue is empty, nothing left to skip to", nil
+				}
+
+				return fmt.Sprintf("Skipped, now playing %s", track.Info.Title), nil
+			},
+		},
+		{
+			Name:        "music_queue",
+			Description: "List the songs waiting in the music queue",
+			Handler: func(ctx context.Context, tc ollama.ToolContext, args json.RawMessage) (string, error) {
+				member, err := toolMember(tc)
+				if err != nil {
+					return "", err
+				}
+				err = p.CheckControl(*tc.GuildID, tc.ChannelID, member)
+				if err != nil {
+					return "", err
+				}
+
+				tracks, err := p.Queue(ctx, *tc.GuildID)
+				if err != nil {
+					return "", err
+				}
+				if len(tracks) == 0 {
+					return "The queue is empty", nil
+				}
+
+				return toolTracks(tracks), nil
+			},
+		},
+		{
+			Name:        "music_volume",
+			Description: "Set the music volume",
+			Parameters: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"volume": {
+						"type": "integer",
+						"description": "The volume percentage",
+						"minimum": 0,
+						"maximum": 100
+					}
+				},
+				"required": ["volume"]
+			}`),
+			Handler: func(ctx context.Context, tc ollama.ToolContext, args json.RawMessage) (string, error) {
+				var params struct {
+					Volume int `json:"volume"`
+				}
+				err := json.Unmarshal(args, &params)
+				if err != nil {
+					return "", err
+				}
+				if params.Volume < 0 || params.Volume > 100 {
+					return "", fmt.Errorf("volume must be between 0 and 100")
+				}
+
+				member, err := toolMember(tc)
+				if err != nil {
+					return "", err
+				}
+				err = p.CheckControl(*tc.GuildID, tc.ChannelID, member)
+				if err != nil {
+					return "", err
+				}
+
+				err = p.Volume(ctx, *tc.GuildID, params.Volume)
+				if err != nil {
+					return "", err
+				}
+
+				return fmt.Sprintf("Set volume to %d%%", params.Volume), nil
+			},
+		},
+	}
+}
@@ -0,0 +1,576 @@
+package command
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Akvanvig/roboto-go/internal/bot"
+	"github.com/Akvanvig/roboto-go/internal/ollama"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/handler"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// -- BOOTSTRAP --
+
+var promptChannelOption = discord.ApplicationCommandOptionChannel{
+	Name:        "channel",
+	Description: "The channel, leave out for the server prompt",
+	ChannelTypes: []discord.ChannelType{
+		discord.ChannelTypeGuildText,
+		discord.ChannelTypeGuildVoice,
+	},
+}
+
+func chatCommands(bot *bot.RobotoBot, r *handler.Mux) discord.ApplicationCommandCreate {
+	if bot.Ollama == nil {
+		return nil
+	}
+
+	cmds := discord.SlashCommandCreate{
+		Name:        "chat",
+		Description: "Chat commands",
+		Contexts: []discord.InteractionContextType{
+			discord.InteractionContextTypeGuild,
+		},
+		Options: []discord.ApplicationCommandOption{
+			discord.ApplicationCommandOptionSubCommand{
+				Name:        "ask",
+				Description: "Ask chat a question",
+				Options: []discord.ApplicationCommandOption{
+					discord.ApplicationCommandOptionString{
+						Name:        "question",
+						Description: "The question to ask",
+						Required:    true,
+					},
+					discord.ApplicationCommandOptionBool{
+						Name:        "ephemeral",
+						Description: "Only show the answer to you",
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "model",
+						Description: "The model to use instead of the channel model",
+					},
+					discord.ApplicationCommandOptionFloat{
+						Name:        "temperature",
+						Description: "How creative the answer should be",
+						MinValue:    new(0.1),
+						MaxValue:    new(2.0),
+					},
+				},
+			},
+			discord.ApplicationCommandOptionSubCommand{
+				Name:        "summarize",
+				Description: "Summarize the recent messages of this channel",
+				Options: []discord.ApplicationCommandOption{
+					discord.ApplicationCommandOptionInt{
+						Name:        "messages",
+						Description: "How many of the last messages to summarize",
+						MinValue:    new(1),
+						MaxValue:    new(ollama.MaxSummarizeMessages),
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "since",
+						Description: "How far back to go, like 8h or 72h",
+					},
+					discord.ApplicationCommandOptionBool{
+						Name:        "ephemeral",
+						Description: "Only show the summary to you, defaults to true",
+					},
+				},
+			},
+			discord.ApplicationCommandOptionSubCommand{
+				Name:        "reset",
+				Description: "Make chat forget the conversation in this channel",
+			},
+			discord.ApplicationCommandOptionSubCommandGroup{
+				Name:        "prompt",
+				Description: "Manage the system prompts of this server",
+				Options: []discord.ApplicationCommandOptionSubCommand{
+					{
+						Name:        "set",
+						Description: "Set the system prompt of the server or a channel",
+						Options: []discord.ApplicationCommandOption{
+							discord.ApplicationCommandOptionString{
+								Name:        "prompt",
+								Description: "The system prompt",
+								Required:    true,
+								MaxLength:   new(6000),
+							},
+							promptChannelOption,
+							discord.ApplicationCommandOptionBool{
+								Name:        "exclusive",
+								Description: "Leave out the prompts earlier in the chain, the server prompt for channels",
+							},
+						},
+					},
+					{
+						Name:        "show",
+						Description: "Show the system prompt of the server or a channel",
+						Options: []discord.ApplicationCommandOption{
+							promptChannelOption,
+						},
+					},
+					{
+						Name:        "clear",
+						Description: "Go back to the configured system prompt of the server or a channel",
+						Options: []discord.ApplicationCommandOption{
+							promptChannelOption,
+						},
+					},
+					{
+						Name:        "list",
+						Description: "List the system prompts set in this server",
+					},
+					{
+						Name:        "history",
+						Description: "Show the latest changes to the system prompts of this server",
+					},
+				},
+			},
+		},
+	}
+
+	personas := bot.Ollama.Personas()
+	if len(personas) > 0 {
+		// NOTE:
+		// Discord allows at most 25 choices
+		choices := make([]discord.ApplicationCommandOptionChoiceString, 0, min(len(personas), 25))
+		for _, name := range personas[:min(len(personas), 25)] {
+			choices = append(choices, discord.ApplicationCommandOptionChoiceString{
+				Name:  name,
+				Value: name,
+			})
+		}
+
+		cmds.Options = append(cmds.Options, discord.ApplicationCommandOptionSubCommand{
+			Name:        "persona",
+			Description: "Show or change the persona of chat in this channel",
+			Options: []discord.ApplicationCommandOption{
+				discord.ApplicationCommandOptionString{
+					Name:        "name",
+					Description: "The persona to use",
+					Choices:     choices,
+				},
+				discord.ApplicationCommandOptionBool{
+					Name:        "clear",
+					Description: "Go back to the configured prompt of the channel",
+				},
+			},
+		})
+	}
+
+	h := &ChatHandler{
+		Ollama: bot.Ollama,
+	}
+	r.Route("/chat", func(r handler.Router) {
+		r.SlashCommand("/ask", h.onAsk)
+		r.SlashCommand("/summarize", h.onSummarize)
+		r.Component("/reasoning/{id}", h.onReasoningButton)
+		r.Group(func(r handler.Router) {
+			r.Use(func(next handler.Handler) handler.Handler {
+				return func(e *handler.InteractionEvent) error {
+					member := e.Member()
+					if member == nil || !member.Permissions.Has(discord.PermissionManageChannels) {
+						return e.Respond(discord.InteractionResponseTypeCreateMessage, discord.MessageUpdate{
+							Embeds: new(Embeds("Only channel managers can change chat in this channel", MessageColorError)),
+							Flags:  new(discord.MessageFlagEphemeral),
+						})
+					}
+
+					return next(e)
+				}
+			})
+
+			r.SlashCommand("/reset", h.onReset)
+			r.SlashCommand("/persona", h.onPersona)
+		})
+		r.Route("/prompt", func(r handler.Router) {
+			r.Use(func(next handler.Handler) handler.Handler {
+				return func(e *handler.InteractionEvent) error {
+					member := e.Member()
+					if member == nil || !member.Permissions.Has(discord.PermissionManageGuild) {
+						return e.Respond(discord.InteractionResponseTypeCreateMessage, discord.MessageUpdate{
+							Embeds: new(Embeds("Only server managers can change the chat prompts", MessageColorError)),
+							Flags:  new(discord.MessageFlagEphemeral),
+						})
+					}
+
+					return next(e)
+				}
+			})
+
+			r.SlashCommand("/set", h.onPromptSet)
+			r.SlashCommand("/show", h.onPromptShow)
+			r.SlashCommand("/clear", h.onPromptClear)
+			r.SlashCommand("/list", h.onPromptList)
+			r.SlashCommand("/history", h.onPromptHistory)
+		})
+	})
+
+	return cmds
+}
+
+// -- HANDLERS --
+
+type ChatHandler struct {
+	Ollama *ollama.Ollama
+}
+
+// Answers a deferred interaction with the text, whatever doesn't fit in the response is sent as followups
+func (h *ChatHandler) respond(e *handler.CommandEvent, text string, ephemeral bool) error {
+	msgs := h.Ollama.Messages(text)
+	_, err := e.UpdateInteractionResponse(discord.MessageUpdate{
+		Content:         &msgs[0].Content,
+		Files:           msgs[0].Files,
+		AllowedMentions: msgs[0].AllowedMentions,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range msgs[1:] {
+		if ephemeral {
+			msg.Flags = discord.MessageFlagEphemeral
+		}
+		_, err = e.CreateFollowupMessage(msg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *ChatHandler) onAsk(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	// NOTE:
+	// Generating can take a lot longer than the 3 seconds Discord waits for a response
+	err := e.DeferCreateMessage(data.Bool("ephemeral"))
+	if err != nil {
+		return err
+	}
+
+	opts := ollama.AskOptions{
+		Model: data.String("model"),
+	}
+	if temperature, ok := data.OptFloat("temperature"); ok {
+		opts.Temperature = &temperature
+	}
+
+	answer := h.Ollama.Ask(e.Ctx, e.Client(), *e.GuildID(), e.Channel().ID(), e.Member().Member, data.String("question"), opts)
+	return h.respond(e, answer, data.Bool("ephemeral"))
+}
+
+func summaryText(summary ollama.Summary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Summary of %d messages since %s\n%s\n", summary.Messages, discord.FormattedTimestampMention(summary.From.Unix(), discord.TimestampStyleRelative), summary.Overview)
+
+	if len(summary.Topics) > 0 {
+		b.WriteString("### Topics\n")
+		for _, topic := range summary.Topics {
+			fmt.Fprintf(&b, "- **%s**: %s\n", topic.Title, topic.Summary)
+		}
+	}
+	if len(summary.Decisions) > 0 {
+		b.WriteString("### Decisions\n")
+		for _, decision := range summary.Decisions {
+			fmt.Fprintf(&b, "- %s\n", decision)
+		}
+	}
+	if len(summary.Participants) > 0 {
+		b.WriteString("### Who said what\n")
+		for _, participant := range summary.Participants {
+			fmt.Fprintf(&b, "- **%s**: %s\n", participant.Name, participant.Contribution)
+		}
+	}
+	return b.String()
+}
+
+func (h *ChatHandler) onSummarize(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	// NOTE:
+	// The bot reads the history for the member, who shouldn't learn more than they could scroll back to.
+	// The permissions of the interaction member are those in the channel.
+	member := e.Member()
+	if member == nil || !member.Permissions.Has(discord.PermissionReadMessageHistory) {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds("You can't read the message history of this channel", MessageColorError),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	opts := ollama.SummarizeOptions{
+		Messages: data.Int("messages"),
+	}
+	if since, ok := data.OptString("since"); ok {
+		d, err := time.ParseDuration(since)
+		if err != nil || d <= 0 {
+			return e.CreateMessage(discord.MessageCreate{
+				Embeds: Embeds(fmt.Sprintf("Invalid time range %s, expecting a duration like 8h", since), MessageColorError),
+				Flags:  discord.MessageFlagEphemeral,
+			})
+		}
+		opts.Since = time.Now().Add(-d)
+	}
+
+	ephemeral, ok := data.OptBool("ephemeral")
+	if !ok {
+		ephemeral = true
+	}
+
+	// NOTE:
+	// Long conversations are summarized in several requests, taking a while
+	err := e.DeferCreateMessage(ephemeral)
+	if err != nil {
+		return err
+	}
+
+	summary, err := h.Ollama.Summarize(e.Ctx, e.Client(), *e.GuildID(), e.Channel().ID(), e.User(), opts)
+	if err != nil {
+		var text string
+		switch {
+		case errors.Is(err, ollama.ErrNothingToSummarize):
+			text = "There are no messages to summarize"
+		case errors.Is(err, ollama.ErrRateLimited):
+			text = "Slow down, " + strings.TrimPrefix(err.Error(), ollama.ErrRateLimited.Error()+", ")
+		case errors.Is(err, ollama.ErrBusy):
+			text = "Chat is busy, try again in a bit"
+		default:
+			e.Client().Logger.Error("Failed to summarize", slog.Any("error", err))
+			text = "Failed to summarize the channel"
+		}
+		_, err = e.UpdateInteractionResponse(discord.MessageUpdate{
+			Embeds: new(Embeds(text, MessageColorError)),
+		})
+		return err
+	}
+
+	return h.respond(e, summaryText(summary), ephemeral)
+}
+
+func (h *ChatHandler) onReasoningButton(e *handler.ComponentEvent) error {
+	id, err := snowflake.Parse(e.Vars["id"])
+	if err != nil {
+		return err
+	}
+
+	reasoning, ok := h.Ollama.Reasoning(id)
+	if !ok {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds("The reasoning behind this answer is no longer available", MessageColorError),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	msg := discord.NewMessageCreate().
+		WithEphemeral(true).
+		WithAllowedMentions(&discord.AllowedMentions{})
+	if utf8.RuneCountInString(reasoning) > ollama.MessageMaxLength {
+		msg = msg.AddFiles(discord.NewFile("reasoning.md", "", strings.NewReader(reasoning)))
+	} else {
+		msg = msg.WithContent(reasoning)
+	}
+	return e.CreateMessage(msg)
+}
+
+func (h *ChatHandler) onReset(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	err := h.Ollama.ResetConversation(e.Channel().ID())
+	if err != nil {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds("Failed to reset the conversation", MessageColorError),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: Embeds("Chat forgot the conversation so far", MessageColorDefault),
+	})
+}
+
+func (h *ChatHandler) onPersona(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	channelID := e.Channel().ID()
+
+	name, ok := data.OptString("name")
+	if data.Bool("clear") {
+		name, ok = "", true
+	}
+	if !ok {
+		persona := h.Ollama.Persona(channelID)
+		if persona == "" {
+			persona = "None, using the configured prompt"
+		}
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds(fmt.Sprintf("**Persona:** %s", persona), MessageColorDefault),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	err := h.Ollama.SetPersona(channelID, name)
+	if err != nil {
+		text := "Failed to change the persona"
+		if errors.Is(err, ollama.ErrUnknownPersona) {
+			text = fmt.Sprintf("Unknown persona %s", name)
+		}
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds(text, MessageColorError),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	if name == "" {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds("Chat is back to the configured prompt", MessageColorDefault),
+		})
+	}
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: Embeds(fmt.Sprintf("Chat is now %s", name), MessageColorDefault),
+	})
+}
+
+// Where a prompt command applies, the channel is 0 for the server prompt
+func promptScope(data discord.SlashCommandInteractionData) (snowflake.ID, string) {
+	if channel, ok := data.OptChannel("channel"); ok {
+		return channel.ID, discord.ChannelMention(channel.ID)
+	}
+	return 0, "this server"
+}
+
+func (h *ChatHandler) onPromptSet(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	channelID, scope := promptScope(data)
+
+	err := h.Ollama.SetPrompt(*e.GuildID(), channelID, e.User().ID, data.String("prompt"), data.Bool("exclusive"))
+	if err != nil {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: ErrorEmbeds(err),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: Embeds(fmt.Sprintf("Set the chat prompt of %s", scope), MessageColorDefault),
+		Flags:  discord.MessageFlagEphemeral,
+	})
+}
+
+func (h *ChatHandler) onPromptShow(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	channelID, scope := promptScope(data)
+
+	prompt, ok := h.Ollama.Prompt(*e.GuildID(), channelID)
+	if !ok {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds(fmt.Sprintf("No chat prompt is set for %s, the configured one is used", scope), MessageColorDefault),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	// NOTE:
+	// Embed descriptions are limited to 4096 characters
+	text := prompt.SystemPrompt
+	if runes := []rune(text); len(runes) > 3500 {
+		text = string(runes[:3500]) + "…"
+	}
+
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: Embeds(fmt.Sprintf("**Prompt of %s**\n**Exclusive:** %t\n**Set by:** %s %s\n\n%s",
+			scope,
+			prompt.Exclusive,
+			discord.UserMention(prompt.UpdatedBy),
+			discord.FormattedTimestampMention(prompt.UpdatedAt.Unix(), discord.TimestampStyleRelative),
+			text,
+		), MessageColorDefault),
+		Flags: discord.MessageFlagEphemeral,
+	})
+}
+
+func (h *ChatHandler) onPromptClear(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	channelID, scope := promptScope(data)
+
+	err := h.Ollama.ClearPrompt(*e.GuildID(), channelID, e.User().ID)
+	if err != nil {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds("Failed to clear the chat prompt", MessageColorError),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: Embeds(fmt.Sprintf("Cleared the chat prompt of %s", scope), MessageColorDefault),
+		Flags:  discord.MessageFlagEphemeral,
+	})
+}
+
+func (h *ChatHandler) onPromptList(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	prompts := h.Ollama.Prompts(*e.GuildID())
+	if len(prompts) == 0 {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds("No chat prompts are set in this server", MessageColorDefault),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	// NOTE:
+	// The server prompt first, then the channels
+	slices.SortFunc(prompts, func(a, b ollama.StoredPrompt) int {
+		return cmp.Compare(a.ChannelID, b.ChannelID)
+	})
+
+	var b strings.Builder
+	for _, prompt := range prompts {
+		scope := "Server"
+		if prompt.ChannelID != 0 {
+			scope = discord.ChannelMention(prompt.ChannelID)
+		}
+		exclusive := ""
+		if prompt.Exclusive {
+			exclusive = ", exclusive"
+		}
+		fmt.Fprintf(&b, "**%s:** %d characters%s, set by %s\n", scope, utf8.RuneCountInString(prompt.SystemPrompt), exclusive, discord.UserMention(prompt.UpdatedBy))
+	}
+
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: Embeds(b.String(), MessageColorDefault),
+		Flags:  discord.MessageFlagEphemeral,
+	})
+}
+
+func (h *ChatHandler) onPromptHistory(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	changes, err := h.Ollama.PromptHistory(*e.GuildID(), ollama.PromptHistoryEntries)
+	if err != nil {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds("Failed to read the chat prompt history", MessageColorError),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+	if len(changes) == 0 {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds("The chat prompts of this server were never changed", MessageColorDefault),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	var b strings.Builder
+	for _, change := range changes {
+		scope := "the server"
+		if change.ChannelID != 0 {
+			scope = discord.ChannelMention(change.ChannelID)
+		}
+		fmt.Fprintf(&b, "%s %s **%s** %s", discord.FormattedTimestampMention(change.Time.Unix(), discord.TimestampStyleRelative), discord.UserMention(change.UserID), change.Action, scope)
+		if change.Action == ollama.PromptActionSet {
+			exclusive := ""
+			if change.Exclusive {
+				exclusive = ", exclusive"
+			}
+			fmt.Fprintf(&b, " (%d characters%s)", utf8.RuneCountInString(change.SystemPrompt), exclusive)
+		}
+		b.WriteString("\n")
+	}
+
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: Embeds(b.String(), MessageColorDefault),
+		Flags:  discord.MessageFlagEphemeral,
+	})
+}
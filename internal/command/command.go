@@ -1,6 +1,10 @@
 package command
 
 import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"github.com/Akvanvig/roboto-go/internal/bot"
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/handler"
@@ -15,6 +19,7 @@ type CommandBootstrapper func(*bot.RobotoBot, *handler.Mux) discord.ApplicationC
 var bootstrappers = [...]CommandBootstrapper{
 	ownerCommands,
 	musicCommands,
+	chatCommands,
 }
 
 func New(bot *bot.RobotoBot) ([]discord.ApplicationCommandCreate, *handler.Mux) {
@@ -47,3 +52,21 @@ func Embeds(text string, color int) []discord.Embed {
 		},
 	}
 }
+
+// Capitalizes the first letter of every line, errors are lowercase until they are shown
+func capitalize(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(line)
+		lines[i] = string(unicode.ToUpper(r)) + line[size:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ErrorEmbeds shows an error to the user, joined errors get a line each
+func ErrorEmbeds(err error) []discord.Embed {
+	return Embeds(capitalize(err.Error()), MessageColorError)
+}
@@ -1,10 +1,12 @@
 package command
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Akvanvig/roboto-go/internal/bot"
 	"github.com/Akvanvig/roboto-go/internal/player"
@@ -13,10 +15,6 @@ import (
 	"github.com/disgoorg/disgolink/v3/lavalink"
 )
 
-// See https://github.com/lavalink-devs/youtube-source/blob/ae2b8b316bcd2b2188652d682d2f7fb7dcbbcfd3/common/src/main/java/dev/lavalink/youtube/YoutubeAudioSourceManager.java#L42
-var RegexpYoutubeURL = regexp.MustCompile("^(?:http://|https://|)(?:www\\.|m\\.|music\\.|)youtube\\.com/.*")
-var RegexpYoutubeURLAlt = regexp.MustCompile("^(?:http://|https://|)(?:(?:www\\.|m\\.|music\\.|)youtube\\.com/(?:live|embed|shorts)|(?:www\\.|)youtu\\.be)/(?<videoId>.*)")
-
 // -- BOOTSTRAP --
 
 func musicCommands(bot *bot.RobotoBot, r *handler.Mux) discord.ApplicationCommandCreate {
@@ -105,6 +103,96 @@ func musicCommands(bot *bot.RobotoBot, r *handler.Mux) discord.ApplicationComman
 					},
 				},
 			},
+			discord.ApplicationCommandOptionSubCommand{
+				Name:        "stats",
+				Description: "Show listening statistics for this server",
+				Options: []discord.ApplicationCommandOption{
+					discord.ApplicationCommandOptionString{
+						Name:        "window",
+						Description: "The time window to show, default is all time",
+						Choices: []discord.ApplicationCommandOptionChoiceString{
+							{
+								Name:  "Last 24 hours",
+								Value: "day",
+							},
+							{
+								Name:  "Last 7 days",
+								Value: "week",
+							},
+							{
+								Name:  "Last 30 days",
+								Value: "month",
+							},
+							{
+								Name:  "Last 365 days",
+								Value: "year",
+							},
+						},
+					},
+					discord.ApplicationCommandOptionUser{
+						Name:        "user",
+						Description: "Only show statistics for this user",
+					},
+					discord.ApplicationCommandOptionBool{
+						Name:        "export",
+						Description: "Attach every matching play as a CSV file",
+					},
+				},
+			},
+			discord.ApplicationCommandOptionSubCommand{
+				Name:        "settings",
+				Description: "Show or change the music settings of this server",
+				Options: []discord.ApplicationCommandOption{
+					discord.ApplicationCommandOptionInt{
+						Name:        "volume",
+						Description: "The default volume percentage, 0 uses the player default",
+						MinValue:    new(0),
+						MaxValue:    new(100),
+					},
+					discord.ApplicationCommandOptionChannel{
+						Name:        "announce_channel",
+						Description: "The channel to announce songs in",
+						ChannelTypes: []discord.ChannelType{
+							discord.ChannelTypeGuildText,
+							discord.ChannelTypeGuildVoice,
+						},
+					},
+					discord.ApplicationCommandOptionBool{
+						Name:        "announce_here",
+						Description: "Announce songs in the channel they were requested from",
+					},
+					discord.ApplicationCommandOptionBool{
+						Name:        "announce_tracks",
+						Description: "Post a message for each song that starts playing",
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "source",
+						Description: "The default search source",
+						Choices: []discord.ApplicationCommandOptionChoiceString{
+							{
+								Name:  "YouTube",
+								Value: string(lavalink.SearchTypeYouTube),
+							},
+							{
+								Name:  "YouTube Music",
+								Value: string(lavalink.SearchTypeYouTubeMusic),
+							},
+							{
+								Name:  "SoundCloud",
+								Value: string(lavalink.SearchTypeSoundCloud),
+							},
+						},
+					},
+					discord.ApplicationCommandOptionRole{
+						Name:        "dj_role",
+						Description: "The role required to control the player",
+					},
+					discord.ApplicationCommandOptionBool{
+						Name:        "no_dj_role",
+						Description: "Let everyone control the player",
+					},
+				},
+			},
 		},
 	}
 
@@ -113,39 +201,35 @@ func musicCommands(bot *bot.RobotoBot, r *handler.Mux) discord.ApplicationComman
 	}
 	r.Route("/music", func(r handler.Router) {
 		r.SlashCommand("/play", h.onPlay)
+		r.SlashCommand("/stats", h.onStats)
 		r.Group(func(r handler.Router) {
 			r.Use(func(next handler.Handler) handler.Handler {
 				return func(e *handler.InteractionEvent) error {
-					client := e.Client()
-
-					channelID := h.Player.ChannelID(*e.GuildID())
-					if channelID == nil {
+					member := e.Member()
+					if member == nil || !member.Permissions.Has(discord.PermissionManageGuild) {
 						return e.Respond(discord.InteractionResponseTypeCreateMessage, discord.MessageUpdate{
-							Embeds: new(Embeds("No music is currently playing", MessageColorError)),
+							Embeds: new(Embeds("Only server managers can change the music settings", MessageColorError)),
 							Flags:  new(discord.MessageFlagEphemeral),
 						})
 					}
 
-					caches := client.Caches
-					if *channelID != e.Channel().ID() {
-						channel, _ := caches.Channel(*channelID)
+					return next(e)
+				}
+			})
+
+			r.SlashCommand("/settings", h.onSettings)
+		})
+		r.Group(func(r handler.Router) {
+			r.Use(func(next handler.Handler) handler.Handler {
+				return func(e *handler.InteractionEvent) error {
+					err := h.Player.CheckControl(*e.GuildID(), e.Channel().ID(), *e.Member())
+					if err != nil {
 						return e.Respond(discord.InteractionResponseTypeCreateMessage, discord.MessageUpdate{
-							Embeds: new(Embeds(fmt.Sprintf("Expecting music interactions in the %s channel", channel.Mention()), MessageColorError)),
+							Embeds: new(ErrorEmbeds(err)),
 							Flags:  new(discord.MessageFlagEphemeral),
 						})
 					}
 
-					vsUser, userOk := caches.VoiceState(*e.GuildID(), e.User().ID)
-					vsBot, botOk := caches.VoiceState(*e.GuildID(), e.ApplicationID())
-					if botOk {
-						if !userOk || (*vsUser.ChannelID != *vsBot.ChannelID) {
-							return e.Respond(discord.InteractionResponseTypeCreateMessage, discord.MessageUpdate{
-								Embeds: new(Embeds("Must be in the same voice channel as the bot to interact with it", MessageColorError)),
-								Flags:  new(discord.MessageFlagEphemeral),
-							})
-						}
-					}
-
 					return next(e)
 				}
 			})
@@ -170,31 +254,38 @@ type MusicHandler struct {
 }
 
 func (h *MusicHandler) onPlay(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
-	client := e.Client()
-	vsUser, ok := client.Caches.VoiceState(*e.GuildID(), e.User().ID)
-	if !ok {
+	err := h.Player.CheckDJ(*e.GuildID(), *e.Member())
+	if err != nil {
 		return e.CreateMessage(discord.MessageCreate{
-			Embeds: Embeds("Must be in a voice channel to queue songs", MessageColorError),
+			Embeds: ErrorEmbeds(err),
 			Flags:  discord.MessageFlagEphemeral,
 		})
 	}
 
-	src := data.String("source")
-	q := data.String("query")
+	voiceChannelID, err := h.Player.CheckJoin(*e.GuildID(), e.User().ID)
+	if err != nil {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: ErrorEmbeds(err),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
 
-	switch src {
-	case string(lavalink.SearchTypeSoundCloud):
-		q = lavalink.SearchTypeSoundCloud.Apply(q)
-	case string(lavalink.SearchTypeYouTubeMusic):
-		q = lavalink.SearchTypeYouTubeMusic.Apply(q)
-	default:
-		// If the query is a direct link, we just send the url directly
-		if !RegexpYoutubeURL.MatchString(q) && !RegexpYoutubeURLAlt.MatchString(q) {
-			q = lavalink.SearchTypeYouTube.Apply(q)
-		}
+	textChannelID := e.Channel().ID()
+	if settings := h.Player.Settings(*e.GuildID()); settings.AnnounceChannelID != 0 {
+		textChannelID = settings.AnnounceChannelID
 	}
 
-	err := e.DeferCreateMessage(false)
+	err = h.Player.Preflight(*e.GuildID(), voiceChannelID, textChannelID)
+	if err != nil {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: ErrorEmbeds(err),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	q := h.Player.Query(*e.GuildID(), data.String("query"), lavalink.SearchType(data.String("source")))
+
+	err = e.DeferCreateMessage(false)
 	if err != nil {
 		return err
 	}
@@ -208,28 +299,18 @@ func (h *MusicHandler) onPlay(data discord.SlashCommandInteractionData, e *handl
 				return
 			}
 
-			vsBot, ok := client.Caches.VoiceState(*e.GuildID(), e.ApplicationID())
-			if ok {
-				if *vsUser.ChannelID != *vsBot.ChannelID {
-					e.UpdateInteractionResponse(discord.MessageUpdate{
-						Embeds: new(Embeds("Must be in the same voice channel as the bot to interact with it", MessageColorError)),
-					})
-					return
-				}
-			} else {
-				err := client.UpdateVoiceState(context.Background(), *e.GuildID(), vsUser.ChannelID, false, false)
-				if err != nil {
-					e.UpdateInteractionResponse(discord.MessageUpdate{
-						Embeds: new(Embeds(err.Error(), MessageColorError)),
-					})
-					return
-				}
+			err := h.Player.Join(context.Background(), *e.GuildID(), voiceChannelID)
+			if err != nil {
+				e.UpdateInteractionResponse(discord.MessageUpdate{
+					Embeds: new(ErrorEmbeds(err)),
+				})
+				return
 			}
 
-			err := h.Player.Add(e.Ctx, *e.GuildID(), e.Channel().ID(), e.User(), tracks...)
+			err = h.Player.Add(e.Ctx, *e.GuildID(), e.Channel().ID(), e.User(), tracks...)
 			if err != nil {
 				e.UpdateInteractionResponse(discord.MessageUpdate{
-					Embeds: new(Embeds(err.Error(), MessageColorError)),
+					Embeds: new(ErrorEmbeds(err)),
 				})
 				return
 			}
@@ -386,3 +467,165 @@ func (h *MusicHandler) onQueueButton(e *handler.ComponentEvent) error {
 		Flags:  discord.MessageFlagEphemeral,
 	})
 }
+
+func (h *MusicHandler) onSettings(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	settings := h.Player.Settings(*e.GuildID())
+
+	if volume, ok := data.OptInt("volume"); ok {
+		settings.Volume = volume
+	}
+	if channel, ok := data.OptChannel("announce_channel"); ok {
+		settings.AnnounceChannelID = channel.ID
+	}
+	if here, ok := data.OptBool("announce_here"); ok && here {
+		settings.AnnounceChannelID = 0
+	}
+	if announce, ok := data.OptBool("announce_tracks"); ok {
+		settings.Quiet = !announce
+	}
+	if source, ok := data.OptString("source"); ok {
+		settings.Source = lavalink.SearchType(source)
+	}
+	if role, ok := data.OptRole("dj_role"); ok {
+		settings.DJRoleID = role.ID
+	}
+	if none, ok := data.OptBool("no_dj_role"); ok && none {
+		settings.DJRoleID = 0
+	}
+
+	if len(data.Options) > 0 {
+		err := h.Player.SetSettings(*e.GuildID(), settings)
+		if err != nil {
+			return e.CreateMessage(discord.MessageCreate{
+				Embeds: Embeds("Failed to save music settings", MessageColorError),
+				Flags:  discord.MessageFlagEphemeral,
+			})
+		}
+	}
+
+	volume := "Player default"
+	if settings.Volume > 0 {
+		volume = fmt.Sprintf("%d%%", settings.Volume)
+	}
+	announce := "Channel of the command"
+	if settings.AnnounceChannelID != 0 {
+		announce = discord.ChannelMention(settings.AnnounceChannelID)
+	}
+	source := "YouTube"
+	switch settings.Source {
+	case lavalink.SearchTypeYouTubeMusic:
+		source = "YouTube Music"
+	case lavalink.SearchTypeSoundCloud:
+		source = "SoundCloud"
+	}
+	dj := "Everyone"
+	if settings.DJRoleID != 0 {
+		dj = discord.RoleMention(settings.DJRoleID)
+	}
+
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: []discord.Embed{
+			{
+				Title: "Music settings",
+				Color: MessageColorDefault,
+				Fields: []discord.EmbedField{
+					{Name: "Default volume", Value: volume, Inline: new(true)},
+					{Name: "Announce channel", Value: announce, Inline: new(true)},
+					{Name: "Announce songs", Value: fmt.Sprintf("%t", !settings.Quiet), Inline: new(true)},
+					{Name: "Search source", Value: source, Inline: new(true)},
+					{Name: "DJ role", Value: dj, Inline: new(true)},
+				},
+			},
+		},
+		Flags: discord.MessageFlagEphemeral,
+	})
+}
+
+var StatsWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+}
+
+func (h *MusicHandler) onStats(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	filter := player.StatsFilter{
+		GuildID: *e.GuildID(),
+	}
+
+	title := "Listening stats"
+	if window, ok := StatsWindows[data.String("window")]; ok {
+		filter.Since = time.Now().Add(-window)
+	}
+	if user, ok := data.OptUser("user"); ok {
+		filter.UserID = user.ID
+		title = fmt.Sprintf("Listening stats for %s", user.Username)
+	}
+
+	err := e.DeferCreateMessage(false)
+	if err != nil {
+		return err
+	}
+
+	stats, err := h.Player.Stats(filter, 10)
+	if err != nil {
+		_, err = e.UpdateInteractionResponse(discord.MessageUpdate{
+			Embeds: new(Embeds("Failed to read listening stats", MessageColorError)),
+		})
+		return err
+	}
+
+	if stats.Plays == 0 {
+		_, err = e.UpdateInteractionResponse(discord.MessageUpdate{
+			Embeds: new(Embeds("Nothing has been played yet", MessageColorDefault)),
+		})
+		return err
+	}
+
+	var tracks strings.Builder
+	for i, track := range stats.TopTracks {
+		// NOTE:
+		// Embed fields are limited to 1024 characters
+		name := []rune(track.Title)
+		if len(name) > 60 {
+			name = append(name[:59], '…')
+		}
+		fmt.Fprintf(&tracks, "%d. %s (%d plays)\n", i+1, string(name), track.Plays)
+	}
+
+	var users strings.Builder
+	for i, user := range stats.TopUsers {
+		fmt.Fprintf(&users, "%d. %s (%d plays, %.1f hours)\n", i+1, discord.UserMention(user.UserID), user.Plays, user.Listened.Hours())
+	}
+
+	update := discord.MessageUpdate{
+		Embeds: &[]discord.Embed{
+			{
+				Title: title,
+				Color: MessageColorDefault,
+				Fields: []discord.EmbedField{
+					{Name: "Plays", Value: strconv.Itoa(stats.Plays), Inline: new(true)},
+					{Name: "Hours listened", Value: fmt.Sprintf("%.1f", stats.Listened.Hours()), Inline: new(true)},
+					{Name: "Top tracks", Value: tracks.String()},
+					{Name: "Top requesters", Value: users.String()},
+				},
+			},
+		},
+		AllowedMentions: &discord.AllowedMentions{},
+	}
+
+	if data.Bool("export") {
+		var b bytes.Buffer
+		err = h.Player.ExportStats(filter, &b)
+		if err != nil {
+			update.Embeds = new(append(*update.Embeds, Embeds("Failed to export listening stats", MessageColorError)...))
+		} else {
+			update.Files = []*discord.File{
+				discord.NewFile("music_stats.csv", "Listening stats", &b),
+			}
+		}
+	}
+
+	_, err = e.UpdateInteractionResponse(update)
+	return err
+}
@@ -1,15 +1,27 @@
 package command
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/Akvanvig/roboto-go/internal/bot"
+	"github.com/Akvanvig/roboto-go/internal/ollama"
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/handler"
 )
 
 // -- BOOTSTRAP --
 
+var modelOption = discord.ApplicationCommandOptionString{
+	Name:        "model",
+	Description: "The name of the model, like llama3.2:3b",
+	Required:    true,
+}
+
 func ownerCommands(bot *bot.RobotoBot, r *handler.Mux) discord.ApplicationCommandCreate {
 	cmds := discord.SlashCommandCreate{
 		Name:        "owner",
@@ -32,7 +44,71 @@ func ownerCommands(bot *bot.RobotoBot, r *handler.Mux) discord.ApplicationComman
 		},
 	}
 
-	h := &OwnerHandler{}
+	if bot.Ollama != nil {
+		cmds.Options = append(cmds.Options, discord.ApplicationCommandOptionSubCommand{
+			Name:        "memory",
+			Description: "Show or reset the chat memory of a channel",
+			Options: []discord.ApplicationCommandOption{
+				discord.ApplicationCommandOptionChannel{
+					Name:        "channel",
+					Description: "The channel",
+					Required:    true,
+					ChannelTypes: []discord.ChannelType{
+						discord.ChannelTypeGuildText,
+						discord.ChannelTypeGuildVoice,
+						discord.ChannelTypeGuildPublicThread,
+						discord.ChannelTypeGuildPrivateThread,
+					},
+				},
+				discord.ApplicationCommandOptionBool{
+					Name:        "reset",
+					Description: "Forget the summary of the channel",
+				},
+			},
+		}, discord.ApplicationCommandOptionSubCommandGroup{
+			Name:        "model",
+			Description: "Manage the models on the Ollama server",
+			Options: []discord.ApplicationCommandOptionSubCommand{
+				{
+					Name:        "list",
+					Description: "List the installed models",
+				},
+				{
+					Name:        "running",
+					Description: "List the models loaded in memory",
+				},
+				{
+					Name:        "pull",
+					Description: "Download a model",
+					Options: []discord.ApplicationCommandOption{
+						modelOption,
+					},
+				},
+				{
+					Name:        "load",
+					Description: "Load a model into memory",
+					Options: []discord.ApplicationCommandOption{
+						modelOption,
+						discord.ApplicationCommandOptionString{
+							Name:        "keep_alive",
+							Description: "How long to keep the model loaded, like 30m or 2h. Negative keeps it loaded",
+						},
+					},
+				},
+				{
+					Name:        "unload",
+					Description: "Unload a model from memory",
+					Options: []discord.ApplicationCommandOption{
+						modelOption,
+					},
+				},
+			},
+		})
+	}
+
+	h := &OwnerHandler{
+		Ollama: bot.Ollama,
+	}
 	r.Route("/owner", func(r handler.Router) {
 		r.Use(func(next handler.Handler) handler.Handler {
 			return func(e *handler.InteractionEvent) error {
@@ -61,6 +137,14 @@ func ownerCommands(bot *bot.RobotoBot, r *handler.Mux) discord.ApplicationComman
 		})
 
 		r.SlashCommand("/run", h.onRun)
+		if h.Ollama != nil {
+			r.SlashCommand("/memory", h.onMemory)
+			r.SlashCommand("/model/list", h.onModelList)
+			r.SlashCommand("/model/running", h.onModelRunning)
+			r.SlashCommand("/model/pull", h.onModelPull)
+			r.SlashCommand("/model/load", h.onModelLoad)
+			r.SlashCommand("/model/unload", h.onModelUnload)
+		}
 	})
 
 	return cmds
@@ -69,6 +153,7 @@ func ownerCommands(bot *bot.RobotoBot, r *handler.Mux) discord.ApplicationComman
 // -- HANDLERS --
 
 type OwnerHandler struct {
+	Ollama *ollama.Ollama
 }
 
 func (h *OwnerHandler) onRun(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
@@ -96,3 +181,235 @@ func (h *OwnerHandler) onRun(data discord.SlashCommandInteractionData, e *handle
 		Flags:  discord.MessageFlagEphemeral,
 	})
 }
+
+func (h *OwnerHandler) onMemory(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	channelID := data.Channel("channel").ID
+
+	if data.Bool("reset") {
+		err := h.Ollama.ResetMemory(channelID)
+		if err != nil {
+			return e.CreateMessage(discord.MessageCreate{
+				Embeds: Embeds("Failed to reset the chat memory", MessageColorError),
+				Flags:  discord.MessageFlagEphemeral,
+			})
+		}
+
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds("Reset the chat memory of "+discord.ChannelMention(channelID), MessageColorDefault),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	memory, ok := h.Ollama.Memory(channelID)
+	if !ok {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds(discord.ChannelMention(channelID)+" has no chat memory", MessageColorDefault),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: Embeds(truncateEmbed(fmt.Sprintf("**Updated:** %s\n\n%s", discord.FormattedTimestampMention(memory.UpdatedAt.Unix(), discord.TimestampStyleRelative), memory.Summary)), MessageColorDefault),
+		Flags:  discord.MessageFlagEphemeral,
+	})
+}
+
+const (
+	// NOTE:
+	// Embed descriptions are limited to 4096 characters
+	embedMaxLength = 4096
+	// NOTE:
+	// The interaction token expires after 15 minutes, after which the progress can't be shown anymore
+	pullTimeout = 14 * time.Minute
+)
+
+func formatSize(size int64) string {
+	return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+}
+
+// Joins the lines as far as they fit in an embed, noting how many were left out
+func joinLines(lines []string) string {
+	var b strings.Builder
+	for i, line := range lines {
+		more := fmt.Sprintf("…and %d more", len(lines)-i)
+		if utf8.RuneCountInString(b.String())+utf8.RuneCountInString(line)+len(more) > embedMaxLength {
+			b.WriteString(more)
+			break
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// Cuts the text off so it fits in an embed
+func truncateEmbed(text string) string {
+	if utf8.RuneCountInString(text) <= embedMaxLength {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:embedMaxLength-1]) + "…"
+}
+
+func (h *OwnerHandler) onModelList(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	models, err := h.Ollama.Models(e.Ctx)
+	if err != nil {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds(fmt.Sprintf("Failed to list the models: %s", err), MessageColorError),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+	if len(models) == 0 {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds("No models are installed", MessageColorDefault),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	lines := make([]string, 0, len(models))
+	for _, model := range models {
+		lines = append(lines, fmt.Sprintf("**%s** %s, %s %s", model.Name, formatSize(model.Size), model.Details.ParameterSize, model.Details.QuantizationLevel))
+	}
+
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: Embeds(joinLines(lines), MessageColorDefault),
+		Flags:  discord.MessageFlagEphemeral,
+	})
+}
+
+func (h *OwnerHandler) onModelRunning(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	models, err := h.Ollama.RunningModels(e.Ctx)
+	if err != nil {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds(fmt.Sprintf("Failed to list the running models: %s", err), MessageColorError),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+	if len(models) == 0 {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds("No models are loaded", MessageColorDefault),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	lines := make([]string, 0, len(models))
+	for _, model := range models {
+		gpu := 0
+		if model.Size > 0 {
+			gpu = int(model.SizeVRAM * 100 / model.Size)
+		}
+		lines = append(lines, fmt.Sprintf("**%s** %s, %d%% GPU, unloads %s", model.Name, formatSize(model.Size), gpu, discord.FormattedTimestampMention(model.ExpiresAt.Unix(), discord.TimestampStyleRelative)))
+	}
+
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: Embeds(joinLines(lines), MessageColorDefault),
+		Flags:  discord.MessageFlagEphemeral,
+	})
+}
+
+func (h *OwnerHandler) onModelPull(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	model := data.String("model")
+
+	err := e.DeferCreateMessage(true)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pullTimeout)
+	defer cancel()
+
+	// NOTE:
+	// Progress arrives many times a second, the message is only edited every so often
+	edited := time.Now()
+	err = h.Ollama.Pull(ctx, model, func(progress ollama.OllamaPullProgress) error {
+		if time.Since(edited) < ollama.StreamEditInterval {
+			return nil
+		}
+		edited = time.Now()
+
+		text := fmt.Sprintf("Pulling %s: %s", model, progress.Status)
+		if progress.Total > 0 {
+			text += fmt.Sprintf(" %d%% of %s", progress.Completed*100/progress.Total, formatSize(progress.Total))
+		}
+		_, err := e.UpdateInteractionResponse(discord.MessageUpdate{
+			Embeds: new(Embeds(text, MessageColorDefault)),
+		})
+		if err != nil {
+			e.Client().Logger.Warn("Failed to update pull progress", slog.Any("error", err))
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		_, err = e.UpdateInteractionResponse(discord.MessageUpdate{
+			Embeds: new(Embeds(fmt.Sprintf("Pulling %s is taking longer than %s, pull it again to continue where it stopped", model, pullTimeout), MessageColorError)),
+		})
+		return err
+	}
+	if err != nil {
+		_, err = e.UpdateInteractionResponse(discord.MessageUpdate{
+			Embeds: new(Embeds(fmt.Sprintf("Failed to pull %s: %s", model, err), MessageColorError)),
+		})
+		return err
+	}
+
+	_, err = e.UpdateInteractionResponse(discord.MessageUpdate{
+		Embeds: new(Embeds(fmt.Sprintf("Pulled %s", model), MessageColorDefault)),
+	})
+	return err
+}
+
+func (h *OwnerHandler) onModelLoad(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	model := data.String("model")
+
+	keepAlive := 5 * time.Minute
+	if value, ok := data.OptString("keep_alive"); ok {
+		var err error
+		keepAlive, err = time.ParseDuration(value)
+		if err != nil {
+			return e.CreateMessage(discord.MessageCreate{
+				Embeds: Embeds(fmt.Sprintf("Invalid keep alive %s, expecting a duration like 30m", value), MessageColorError),
+				Flags:  discord.MessageFlagEphemeral,
+			})
+		}
+	}
+
+	// NOTE:
+	// Loading a large model can take longer than Discord waits for a response
+	err := e.DeferCreateMessage(true)
+	if err != nil {
+		return err
+	}
+
+	err = h.Ollama.KeepAlive(e.Ctx, model, keepAlive)
+	if err != nil {
+		_, err = e.UpdateInteractionResponse(discord.MessageUpdate{
+			Embeds: new(Embeds(fmt.Sprintf("Failed to load %s: %s", model, err), MessageColorError)),
+		})
+		return err
+	}
+
+	text := fmt.Sprintf("Loaded %s for %s", model, keepAlive)
+	if keepAlive < 0 {
+		text = fmt.Sprintf("Loaded %s until it is unloaded", model)
+	}
+	_, err = e.UpdateInteractionResponse(discord.MessageUpdate{
+		Embeds: new(Embeds(text, MessageColorDefault)),
+	})
+	return err
+}
+
+func (h *OwnerHandler) onModelUnload(data discord.SlashCommandInteractionData, e *handler.CommandEvent) error {
+	model := data.String("model")
+
+	err := h.Ollama.KeepAlive(e.Ctx, model, 0)
+	if err != nil {
+		return e.CreateMessage(discord.MessageCreate{
+			Embeds: Embeds(fmt.Sprintf("Failed to unload %s: %s", model, err), MessageColorError),
+			Flags:  discord.MessageFlagEphemeral,
+		})
+	}
+
+	return e.CreateMessage(discord.MessageCreate{
+		Embeds: Embeds(fmt.Sprintf("Unloaded %s", model), MessageColorDefault),
+		Flags:  discord.MessageFlagEphemeral,
+	})
+}
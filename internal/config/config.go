@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"time"
 
 	"dario.cat/mergo"
 	"github.com/disgoorg/disgolink/v3/disgolink"
@@ -18,7 +20,77 @@ type DiscordConfig struct {
 }
 
 type LavalinkConfig struct {
-	Nodes []disgolink.NodeConfig `yaml:"nodes"`
+	Nodes       []disgolink.NodeConfig `yaml:"nodes"`
+	TrackStatus bool                   `yaml:"trackStatus,omitempty"` // set the stage topic or voice channel status to the playing track
+}
+
+type OllamaContextConfig struct {
+	Strategy    string        `yaml:"strategy"`              // "reply" follows the reply chain (default), "history" takes the last messages and "window" the messages within a time window
+	Messages    int           `yaml:"messages,omitempty"`    // max messages for the history strategy, defaults to 20
+	Window      time.Duration `yaml:"window,omitempty"`      // time window for the window strategy, defaults to 30m
+	TokenBudget int           `yaml:"tokenBudget,omitempty"` // approximate max tokens of context, 0 is unlimited
+}
+
+// Decides which messages the chat listener answers.
+// Fields set in a more specific config override the earlier ones in the chain Default < Server < Channel,
+// except for the ignore lists that add up.
+type OllamaTriggerConfig struct {
+	Phrases        []string       `yaml:"phrases,omitempty"`        // case-insensitive phrases contained in the message, defaults to "hey chat"
+	Patterns       []string       `yaml:"patterns,omitempty"`       // regular expressions matching the message
+	Mention        *bool          `yaml:"mention,omitempty"`        // answer messages mentioning the bot, defaults to false
+	Reply          *bool          `yaml:"reply,omitempty"`          // answer replies to the bot, defaults to true
+	DirectMessages *bool          `yaml:"directMessages,omitempty"` // answer every direct message, defaults to false. only applies to the default trigger
+	Always         *bool          `yaml:"always,omitempty"`         // answer every message, meant for dedicated channels
+	Probability    *float64       `yaml:"probability,omitempty"`    // chance between 0 and 1 of answering any other message
+	Bots           *bool          `yaml:"bots,omitempty"`           // answer messages from other bots, defaults to false
+	IgnoreUsers    []snowflake.ID `yaml:"ignoreUsers,omitempty"`
+	IgnoreRoles    []snowflake.ID `yaml:"ignoreRoles,omitempty"`
+	IgnoreChannels []snowflake.ID `yaml:"ignoreChannels,omitempty"`
+}
+
+// Generation options of the model.
+// Fields set in a more specific config override the earlier ones in the chain Default < Server < Channel.
+type OllamaOptionsConfig struct {
+	Temperature *float64       `yaml:"temperature,omitempty"` // between 0 and 2, defaults to 1.5
+	TopK        *int           `yaml:"topK,omitempty"`        // sample from the k most likely tokens, at least 1
+	TopP        *float64       `yaml:"topP,omitempty"`        // sample from the most likely tokens adding up to p, between 0 and 1
+	MinP        *float64       `yaml:"minP,omitempty"`        // leave out tokens less likely than p times the most likely one, between 0 and 1
+	NumPredict  *int           `yaml:"numPredict,omitempty"`  // max tokens of an answer, -1 is unlimited
+	Seed        *int           `yaml:"seed,omitempty"`        // makes answers repeatable
+	Stop        []string       `yaml:"stop,omitempty"`        // sequences ending the answer
+	Think       string         `yaml:"think,omitempty"`       // "true", "false" or the effort "high", "medium" or "low" for thinking models
+	KeepAlive   *time.Duration `yaml:"keepAlive,omitempty"`   // how long the model stays loaded after a request, negative keeps it loaded
+	Format      string         `yaml:"format,omitempty"`      // "json" makes the model answer in JSON
+}
+
+var ollamaThinkValues = []string{"true", "false", "high", "medium", "low"}
+
+func (o *OllamaOptionsConfig) validate() error {
+	var errs error
+
+	if o.Temperature != nil && (*o.Temperature < 0 || *o.Temperature > 2) {
+		errs = errors.Join(errs, fmt.Errorf("temperature must be between 0 and 2, got %v", *o.Temperature))
+	}
+	if o.TopK != nil && *o.TopK < 1 {
+		errs = errors.Join(errs, fmt.Errorf("topK must be at least 1, got %d", *o.TopK))
+	}
+	if o.TopP != nil && (*o.TopP < 0 || *o.TopP > 1) {
+		errs = errors.Join(errs, fmt.Errorf("topP must be between 0 and 1, got %v", *o.TopP))
+	}
+	if o.MinP != nil && (*o.MinP < 0 || *o.MinP > 1) {
+		errs = errors.Join(errs, fmt.Errorf("minP must be between 0 and 1, got %v", *o.MinP))
+	}
+	if o.NumPredict != nil && (*o.NumPredict < -1 || *o.NumPredict == 0) {
+		errs = errors.Join(errs, fmt.Errorf("numPredict must be positive or -1, got %d", *o.NumPredict))
+	}
+	if o.Think != "" && !slices.Contains(ollamaThinkValues, o.Think) {
+		errs = errors.Join(errs, fmt.Errorf("think must be one of %v, got %q", ollamaThinkValues, o.Think))
+	}
+	if o.Format != "" && o.Format != "json" {
+		errs = errors.Join(errs, fmt.Errorf("format must be json, got %q", o.Format))
+	}
+
+	return errs
 }
 
 type OllamaSystemPromptConfig struct {
@@ -26,21 +98,91 @@ type OllamaSystemPromptConfig struct {
 	Model        string `yaml:"model"`        // override model to use in ollama request. requires model present in ollama
 	Exclusive    bool   `yaml:"exclusive"`    // removes system-prompts earlier in the chain Default < Server < Channel
 	SystemPrompt string `yaml:"systemPrompt"` // system-prompt to provide when used
+	Vision       bool   `yaml:"vision"`       // the model accepts images. applies together with the model
+	NumCtx       int    `yaml:"numCtx"`       // context window of the model in tokens, defaults to 4096. applies together with the model
+	// how to gather the conversation preceding a message. the most specific config wins
+	Context *OllamaContextConfig `yaml:"context,omitempty"`
+	// which messages to answer, see OllamaTriggerConfig
+	Trigger *OllamaTriggerConfig `yaml:"trigger,omitempty"`
+	// names of the backends to use in order, the next one is tried when one is down. applies together with the model.
+	// defaults to the top level server, which can be included as "default"
+	Backends []string `yaml:"backends,omitempty"`
+	// generation options, see OllamaOptionsConfig
+	Options *OllamaOptionsConfig `yaml:"options,omitempty"`
+}
+
+type OllamaToolsConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	MaxIterations int           `yaml:"maxIterations,omitempty"` // max rounds of tool calls per message, defaults to 5
+	Timeout       time.Duration `yaml:"timeout,omitempty"`       // max time spent on a message including tool calls, defaults to 60s
+}
+
+type OllamaMemoryConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Threshold int    `yaml:"threshold,omitempty"` // new messages needed before the summary is updated, defaults to 40
+	Keep      int    `yaml:"keep,omitempty"`      // most recent messages left out of the summary, defaults to 20
+	Model     string `yaml:"model,omitempty"`     // model used for summarizing, defaults to the channel model
+}
+
+type OllamaRateLimitConfig struct {
+	Every time.Duration `yaml:"every"`           // one request is regained every duration
+	Burst int           `yaml:"burst,omitempty"` // requests that can be made at once, defaults to 1
+}
+
+type OllamaLimitsConfig struct {
+	User        *OllamaRateLimitConfig `yaml:"user,omitempty"`
+	Channel     *OllamaRateLimitConfig `yaml:"channel,omitempty"`
+	Guild       *OllamaRateLimitConfig `yaml:"guild,omitempty"`
+	Concurrency int                    `yaml:"concurrency,omitempty"` // max requests to ollama at once, defaults to 2
+	Queue       int                    `yaml:"queue,omitempty"`       // max requests waiting for their turn, defaults to 10
+}
+
+type OllamaBackendConfig struct {
+	Type     string `yaml:"type,omitempty"`     // "ollama" or "openai" for OpenAI compatible servers, defaults to "ollama"
+	Server   string `yaml:"server"`             // base url of the server
+	ChatPath string `yaml:"chatPath,omitempty"` // defaults to /api/chat for ollama and /v1/chat/completions for openai
+	APIKey   string `yaml:"apiKey,omitempty"`   // sent as a bearer token
+	Model    string `yaml:"model,omitempty"`    // name of the model on this backend, defaults to the model of the prompt
+}
+
+type OllamaPromptsConfig struct {
+	MaxLength      int `yaml:"maxLength,omitempty"`      // max length of a prompt set with commands, defaults to 2000
+	MaxGuildLength int `yaml:"maxGuildLength,omitempty"` // max length of all prompts of a server set with commands together, defaults to 8000
 }
 
 type OllamaConfig struct {
 	Server         string                                    `yaml:"server,omitempty"`
 	ChatPath       string                                    `yaml:"chatPath,omitempty"`
 	GeneratePath   string                                    `yaml:"generatePath,omitempty"`
+	Timeout        time.Duration                             `yaml:"timeout,omitempty"`      // max time of a single request to ollama, defaults to 5m
+	Retries        int                                       `yaml:"retries,omitempty"`      // attempts after a failed request, defaults to 2. negative disables retries
+	RetryBackoff   time.Duration                             `yaml:"retryBackoff,omitempty"` // wait before the first retry, doubled for every attempt. defaults to 500ms
+	Backends       map[string]OllamaBackendConfig            `yaml:"backends,omitempty"`     // additional LLM servers prompts can choose from, name as key
 	DefaultPrompt  OllamaSystemPromptConfig                  `yaml:"defaultPrompt,omitempty"`
 	ServerPrompts  map[snowflake.ID]OllamaSystemPromptConfig `yaml:"serverPrompts,omitempty"`  // server/channel id as key
 	ChannelPrompts map[snowflake.ID]OllamaSystemPromptConfig `yaml:"channelPrompts,omitempty"` // server/channel id as key
+	Personas       []OllamaSystemPromptConfig                `yaml:"personas,omitempty"`       // named prompts a channel can switch to with /chat persona
+	Stream         bool                                      `yaml:"stream,omitempty"`         // edit the reply progressively as the answer is generated
+	Tools          *OllamaToolsConfig                        `yaml:"tools,omitempty"`          // Optional, lets the model call bot-side tools
+	MaxImages      int                                       `yaml:"maxImages,omitempty"`      // max images passed to vision models per message, defaults to 4
+	MaxImageSize   int                                       `yaml:"maxImageSize,omitempty"`   // max size in bytes of each image, defaults to 8 MiB
+	Memory         *OllamaMemoryConfig                       `yaml:"memory,omitempty"`         // Optional, keeps a rolling summary of each channel
+	Limits         *OllamaLimitsConfig                       `yaml:"limits,omitempty"`         // Optional, rate limits per user, channel and guild
+	AttachLength   int                                       `yaml:"attachLength,omitempty"`   // answers longer than this are sent as a markdown file instead of split messages, 0 always splits
+	Mentions       string                                    `yaml:"mentions,omitempty"`       // who answers may ping, "reply" for the user replied to or "none". defaults to "reply"
+	Prompts        *OllamaPromptsConfig                      `yaml:"prompts,omitempty"`        // Optional, limits of the prompts set with /chat prompt
+	Reasoning      string                                    `yaml:"reasoning,omitempty"`      // how to show the reasoning of thinking models, "spoiler", "button" or "hide". defaults to "hide"
+}
+
+type StorageConfig struct {
+	Path string `yaml:"path"` // directory for persistent bot data such as per-guild settings
 }
 
 type RobotoConfig struct {
 	Discord  *DiscordConfig  `yaml:"discord"`
 	Lavalink *LavalinkConfig `yaml:"lavalink"` // Optional
 	Ollama   *OllamaConfig   `yaml:"ollama"`
+	Storage  *StorageConfig  `yaml:"storage"` // Optional, defaults to ./data
 }
 
 func resolve(path string) (string, error) {
@@ -114,6 +256,29 @@ func validate(cfg *RobotoConfig) error {
 
 	}
 
+	if cfg.Ollama != nil {
+		prompts := map[string]OllamaSystemPromptConfig{"default prompt": cfg.Ollama.DefaultPrompt}
+		for id, prompt := range cfg.Ollama.ServerPrompts {
+			prompts[fmt.Sprintf("server prompt %s", id)] = prompt
+		}
+		for id, prompt := range cfg.Ollama.ChannelPrompts {
+			prompts[fmt.Sprintf("channel prompt %s", id)] = prompt
+		}
+		for i, persona := range cfg.Ollama.Personas {
+			prompts[fmt.Sprintf("persona %d %s", i+1, persona.Name)] = persona
+		}
+
+		for name, prompt := range prompts {
+			if prompt.Options == nil {
+				continue
+			}
+			err := prompt.Options.validate()
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("ollama config has invalid options for the %s: %w", name, err))
+			}
+		}
+	}
+
 	return errs
 }
 
@@ -133,6 +298,12 @@ func New() (*RobotoConfig, error) {
 		}
 	}
 
+	if cfg.Storage == nil || cfg.Storage.Path == "" {
+		cfg.Storage = &StorageConfig{
+			Path: "./data",
+		}
+	}
+
 	err = validate(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to validate merged config: %w", err)
@@ -0,0 +1,62 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// AskOptions overrides the channel defaults for a single question
+type AskOptions struct {
+	Model       string   // model to use instead of the channel model
+	Temperature *float64 // temperature to use instead of the channel temperature
+}
+
+// Ask answers a single question in the channel without the preceding conversation.
+// Errors are logged and answered with a friendly message.
+func (o *Ollama) Ask(ctx context.Context, client *bot.Client, guildID snowflake.ID, channelID snowflake.ID, member discord.Member, question string, opts AskOptions) string {
+	user := member.User
+	if wait := o.limits.allow(user.ID, channelID, guildID); wait > 0 {
+		return fmt.Sprintf(RateLimited, max(wait, time.Second).Round(time.Second))
+	}
+	release, err := o.limits.acquire(ctx)
+	if err != nil {
+		return Busy
+	}
+	defer release()
+
+	messages := o.prompts(guildID, channelID)
+	if memory, ok := o.memoryPrompt(channelID); ok {
+		messages = append(messages, memory)
+	}
+	messages = append(messages, OllamaChatMessage{
+		Role:    OllamaChatMessageRoleUser,
+		Content: fmt.Sprintf("'%s' says:\n%s", member.EffectiveName(), resolveMentions(client, discord.Message{GuildID: &guildID, Author: user, Member: &member, Content: question})),
+	})
+
+	chat := OllamaChat{
+		Model:    o.model(guildID, channelID),
+		Messages: messages,
+	}
+	o.applyOptions(&chat, guildID, channelID)
+	if opts.Model != "" {
+		chat.Model = opts.Model
+	}
+	if opts.Temperature != nil {
+		chat.Options.Temperature = opts.Temperature
+	}
+	o.fit(&chat, o.modelConfig(guildID, channelID).NumCtx)
+
+	res, err := o.complete(ctx, chat, ToolContext{
+		Client:    client,
+		GuildID:   &guildID,
+		ChannelID: channelID,
+		User:      user,
+		Member:    &member,
+	}, nil)
+	return o.answer(res, err, user)
+}
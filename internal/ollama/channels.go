@@ -0,0 +1,104 @@
+package ollama
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+var ErrUnknownPersona = errors.New("unknown persona")
+
+// ChannelState holds the chat choices made for a channel through commands
+type ChannelState struct {
+	Persona string       `json:"persona,omitempty"` // name of the persona replacing the channel prompt
+	Since   snowflake.ID `json:"since,omitempty"`   // messages up to this one are left out of the conversation
+}
+
+// Get the channel prompt, a chosen persona replaces the configured one and a stored prompt its system prompt
+func (o *Ollama) channelPrompt(channelID snowflake.ID) config.OllamaSystemPromptConfig {
+	if state, ok := o.channels.Get(channelID); ok && state.Persona != "" {
+		if persona, ok := o.persona(state.Persona); ok {
+			return persona
+		}
+	}
+	stored, ok := o.storedPrompts.Get(channelID)
+	return layerPrompt(o.cfg.ChannelPrompts[channelID], stored, ok)
+}
+
+func (o *Ollama) persona(name string) (config.OllamaSystemPromptConfig, bool) {
+	for _, persona := range o.cfg.Personas {
+		if persona.Name == name {
+			return persona, true
+		}
+	}
+	return config.OllamaSystemPromptConfig{}, false
+}
+
+// Personas returns the names of the configured personas
+func (o *Ollama) Personas() []string {
+	names := make([]string, 0, len(o.cfg.Personas))
+	for _, persona := range o.cfg.Personas {
+		names = append(names, persona.Name)
+	}
+	return names
+}
+
+// Persona returns the name of the persona chosen for the channel, if any
+func (o *Ollama) Persona(channelID snowflake.ID) string {
+	state, _ := o.channels.Get(channelID)
+	return state.Persona
+}
+
+// SetPersona chooses the persona of the channel, an empty name restores the configured prompt
+func (o *Ollama) SetPersona(channelID snowflake.ID, name string) error {
+	if name != "" {
+		if _, ok := o.persona(name); !ok {
+			return ErrUnknownPersona
+		}
+	}
+
+	return o.setChannelState(channelID, func(state ChannelState) ChannelState {
+		state.Persona = name
+		return state
+	})
+}
+
+// ResetConversation makes the chat forget everything said in the channel until now
+func (o *Ollama) ResetConversation(channelID snowflake.ID) error {
+	err := o.setChannelState(channelID, func(state ChannelState) ChannelState {
+		state.Since = snowflake.New(time.Now())
+		return state
+	})
+	if err != nil {
+		return err
+	}
+
+	o.unsummarized.reset(channelID)
+	return o.memories.Delete(channelID)
+}
+
+func (o *Ollama) setChannelState(channelID snowflake.ID, fn func(ChannelState) ChannelState) error {
+	return o.channels.Modify(channelID, func(state ChannelState, _ bool) (ChannelState, bool) {
+		state = fn(state)
+		return state, state != (ChannelState{})
+	})
+}
+
+// Get the message the conversation in the channel starts after
+func (o *Ollama) since(channelID snowflake.ID) snowflake.ID {
+	state, _ := o.channels.Get(channelID)
+	return state.Since
+}
+
+// Drops the messages sent before a reset from a newest first list
+func after(msgs []discord.Message, since snowflake.ID) []discord.Message {
+	for i, msg := range msgs {
+		if msg.ID <= since {
+			return msgs[:i]
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,231 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+)
+
+const (
+	DefaultTimeout      = 5 * time.Minute
+	DefaultRetries      = 2
+	DefaultRetryBackoff = 500 * time.Millisecond
+	// NOTE:
+	// Ollama error bodies are small JSON objects, anything larger is not worth reading
+	maxErrorBody = 64 << 10
+)
+
+var (
+	ErrStreamIncomplete = errors.New("stream ended before completion")
+	ErrStreamFailed     = errors.New("stream failed")
+)
+
+// A StatusError is returned when the server answers with a non-successful status code
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Message    string // the error reported by the server, if any
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("server responded with '%s': %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("server responded with '%s'", e.Status)
+}
+
+// Server errors and rate limits might go away by themselves, other client errors won't
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Client does the HTTP requests to an LLM server, retrying temporary failures
+type Client struct {
+	HTTP    *http.Client
+	Server  string
+	Header  http.Header // sent with every request
+	Retries int
+	Backoff time.Duration // doubled after every failed attempt
+	logger  *slog.Logger
+}
+
+func NewClient(logger *slog.Logger, cfg *config.OllamaConfig, server string) *Client {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	retries := cfg.Retries
+	if retries < 0 {
+		retries = 0
+	} else if retries == 0 {
+		retries = DefaultRetries
+	}
+	backoff := cfg.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	return &Client{
+		HTTP: &http.Client{
+			Timeout: timeout,
+		},
+		Server:  server,
+		Header:  make(http.Header),
+		Retries: retries,
+		Backoff: backoff,
+		logger:  logger,
+	}
+}
+
+// Reads the error ollama sends along with a non-successful status code
+func statusError(resp *http.Response) *StatusError {
+	statusErr := &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+
+	// NOTE:
+	// Ollama sends the error as a string, OpenAI compatible servers as an object with a message
+	var body struct {
+		Error json.RawMessage `json:"error"`
+	}
+	var object struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if json.Unmarshal(data, &body) != nil {
+		return statusErr
+	}
+	if json.Unmarshal(body.Error, &statusErr.Message) != nil && json.Unmarshal(body.Error, &object) == nil {
+		statusErr.Message = object.Message
+	}
+	return statusErr
+}
+
+// Whether the request is worth another attempt
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if statusErr, ok := errors.AsType[*StatusError](err); ok {
+		return statusErr.Temporary()
+	}
+
+	// NOTE:
+	// A timed out request already took all its time, and the server might still be working on it
+	if errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if netErr, ok := errors.AsType[net.Error](err); ok && netErr.Timeout() {
+		return false
+	}
+
+	// NOTE:
+	// Only requests that never reached the server are safe to send again
+	if opErr, ok := errors.AsType[*net.OpError](err); ok && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// Post sends the body as JSON to the path on the server.
+// The caller is responsible for closing the body of the returned response.
+func (c *Client) Post(ctx context.Context, path string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return c.request(ctx, http.MethodPost, path, data)
+}
+
+// Get fetches the path on the server, and decodes the response into out
+func (c *Client) Get(ctx context.Context, path string, out any) error {
+	resp, err := c.request(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	return decode(resp, out)
+}
+
+func (c *Client) request(ctx context.Context, method string, path string, data []byte) (*http.Response, error) {
+	endpoint, err := url.JoinPath(c.Server, path)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(ctx, method, endpoint, data)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= c.Retries || !retryable(ctx, err) {
+			return nil, err
+		}
+
+		c.logger.Warn("Request failed, retrying", slog.String("server", c.Server), slog.String("path", path), slog.Int("attempt", attempt+1), slog.Duration("backoff", backoff), slog.Any("error", err))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, endpoint string, data []byte) (*http.Response, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusError(resp)
+	}
+	return resp, nil
+}
+
+// Do posts the body to the path on the server, and decodes the response into out
+func (c *Client) Do(ctx context.Context, path string, body any, out any) error {
+	resp, err := c.Post(ctx, path, body)
+	if err != nil {
+		return err
+	}
+	return decode(resp, out)
+}
+
+func decode(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+
+	err := json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
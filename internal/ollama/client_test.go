@@ -0,0 +1,204 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+)
+
+func newTestClient(server string) *Client {
+	return NewClient(slog.New(slog.DiscardHandler), &config.OllamaConfig{
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+	}, server)
+}
+
+// A server answering with the given status and body until it failed the given number of times
+func failingServer(t *testing.T, failures int, status int, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(attempts.Add(1)) <= failures {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+			return
+		}
+		w.Write([]byte(`{"response":"ok"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &attempts
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		status   int
+		body     string
+		attempts int32
+		err      string // the message of the status error, empty if the request succeeds
+	}{
+		{
+			name:     "server error",
+			failures: 2,
+			status:   http.StatusInternalServerError,
+			body:     `{"error":"model crashed"}`,
+			attempts: 3,
+		},
+		{
+			name:     "rate limited",
+			failures: 1,
+			status:   http.StatusTooManyRequests,
+			attempts: 2,
+		},
+		{
+			name:     "server error after the last retry",
+			failures: 5,
+			status:   http.StatusServiceUnavailable,
+			body:     `{"error":{"message":"overloaded"}}`,
+			attempts: 3,
+			err:      "overloaded",
+		},
+		{
+			name:     "client error",
+			failures: 1,
+			status:   http.StatusNotFound,
+			body:     `{"error":"model not found"}`,
+			attempts: 1,
+			err:      "model not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, attempts := failingServer(t, tt.failures, tt.status, tt.body)
+			client := newTestClient(srv.URL)
+
+			var res OllamaGenerateResponse
+			err := client.Do(context.Background(), "/api/generate", OllamaGenerate{}, &res)
+			if got := attempts.Load(); got != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, got)
+			}
+
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Response != "ok" {
+					t.Fatalf("expected the response, got %q", res.Response)
+				}
+				return
+			}
+
+			statusErr, ok := errors.AsType[*StatusError](err)
+			if !ok {
+				t.Fatalf("expected a status error, got %v", err)
+			}
+			if statusErr.StatusCode != tt.status || statusErr.Message != tt.err {
+				t.Fatalf("expected %d with %q, got %d with %q", tt.status, tt.err, statusErr.StatusCode, statusErr.Message)
+			}
+		})
+	}
+}
+
+func TestClientMalformedBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "answer", status: http.StatusOK},
+		{name: "error", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"response":`))
+			}))
+			t.Cleanup(srv.Close)
+			client := newTestClient(srv.URL)
+
+			var res OllamaGenerateResponse
+			err := client.Do(context.Background(), "/api/generate", OllamaGenerate{}, &res)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if got := attempts.Load(); got != 1 {
+				t.Fatalf("a malformed body should not be retried, got %d attempts", got)
+			}
+			// NOTE:
+			// An unreadable error body still reports the status
+			if statusErr, ok := errors.AsType[*StatusError](err); ok && statusErr.Message != "" {
+				t.Fatalf("expected no message, got %q", statusErr.Message)
+			}
+		})
+	}
+}
+
+func TestClientRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client := newTestClient("http://" + addr)
+	err = client.Do(context.Background(), "/api/generate", OllamaGenerate{}, nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !retryable(context.Background(), err) {
+		t.Fatalf("a refused connection should be retried, got %v", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Run("client timeout", func(t *testing.T) {
+		attempts.Store(0)
+		client := newTestClient(srv.URL)
+		client.HTTP.Timeout = 50 * time.Millisecond
+
+		err := client.Do(context.Background(), "/api/generate", OllamaGenerate{}, nil)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if got := attempts.Load(); got != 1 {
+			t.Fatalf("a timeout should not be retried, got %d attempts", got)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		attempts.Store(0)
+		client := newTestClient(srv.URL)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err := client.Do(ctx, "/api/generate", OllamaGenerate{}, nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the deadline to be exceeded, got %v", err)
+		}
+		if got := attempts.Load(); got != 1 {
+			t.Fatalf("a timeout should not be retried, got %d attempts", got)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package ollama
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+type ContextStrategy = string
+
+const (
+	ContextStrategyReply   ContextStrategy = "reply"   // follow the reply chain of the message
+	ContextStrategyHistory ContextStrategy = "history" // the last messages of the channel
+	ContextStrategyWindow  ContextStrategy = "window"  // the messages of the channel within a time window
+)
+
+const (
+	DefaultContextMessages = 20
+	DefaultContextWindow   = 30 * time.Minute
+	// NOTE:
+	// Discord won't return more than 100 messages per request
+	MaxContextMessages = 100
+)
+
+// Get the context config, the most specific one wins
+func (o *Ollama) contextConfig(guildID snowflake.ID, channelID snowflake.ID) config.OllamaContextConfig {
+	if cfg := o.channelPrompt(channelID); cfg.Context != nil {
+		return *cfg.Context
+	}
+	if cfg := o.serverPrompt(guildID); cfg.Context != nil {
+		return *cfg.Context
+	}
+	if cfg := o.cfg.DefaultPrompt; cfg.Context != nil {
+		return *cfg.Context
+	}
+	return config.OllamaContextConfig{}
+}
+
+// Follows the reply chain starting at the referenced message, newest first
+func replyChain(client *bot.Client, ref *discord.Message) []discord.Message {
+	msgs := make([]discord.Message, 0, 10)
+	for range MaxContextMessages {
+		// If no message is referenced, drop out of loop
+		if ref == nil {
+			break
+		}
+
+		// Retrieve message if in cache to get more complete data
+		if cachedRef, ok := client.Caches.Message(ref.ChannelID, ref.ID); ok {
+			ref = &cachedRef
+		}
+
+		msgs = append(msgs, *ref)
+		ref = ref.ReferencedMessage
+	}
+	return msgs
+}
+
+// Fetches up to limit messages sent before the given message, newest first.
+// Falls back to the message cache if the API request fails.
+func (o *Ollama) channelHistory(client *bot.Client, channelID snowflake.ID, before snowflake.ID, limit int) []discord.Message {
+	msgs, err := client.Rest.GetMessages(channelID, 0, before, 0, limit)
+	if err == nil {
+		return msgs
+	}
+	o.logger.Warn("Failed to fetch channel history, using cached messages", slog.Any("channel_id", channelID), slog.Any("error", err))
+
+	msgs = msgs[:0]
+	for msg := range client.Caches.Messages(channelID) {
+		if msg.ID < before {
+			msgs = append(msgs, msg)
+		}
+	}
+	slices.SortFunc(msgs, func(a, b discord.Message) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return msgs[:min(limit, len(msgs))]
+}
+
+// Joins consecutive messages by the same author into one, newest first
+func mergeAuthors(msgs []discord.Message) []discord.Message {
+	merged := make([]discord.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if last.Author.ID == msg.Author.ID {
+				last.Content = msg.Content + "\n" + last.Content
+				last.Attachments = append(slices.Clone(msg.Attachments), last.Attachments...)
+				continue
+			}
+		}
+		merged = append(merged, msg)
+	}
+	return merged
+}
+
+// Collects the messages preceding the one being answered, newest first
+func (o *Ollama) conversation(client *bot.Client, msg discord.Message, cfg config.OllamaContextConfig) []discord.Message {
+	limit := cfg.Messages
+	if limit <= 0 {
+		limit = DefaultContextMessages
+	}
+	limit = min(limit, MaxContextMessages)
+
+	var msgs []discord.Message
+	switch cfg.Strategy {
+	case ContextStrategyHistory:
+		msgs = o.channelHistory(client, msg.ChannelID, msg.ID, limit)
+	case ContextStrategyWindow:
+		window := cfg.Window
+		if window <= 0 {
+			window = DefaultContextWindow
+		}
+		since := msg.CreatedAt.Add(-window)
+
+		msgs = o.channelHistory(client, msg.ChannelID, msg.ID, MaxContextMessages)
+		if i := slices.IndexFunc(msgs, func(m discord.Message) bool { return m.CreatedAt.Before(since) }); i >= 0 {
+			msgs = msgs[:i]
+		}
+	default:
+		return stripReasoning(after(replyChain(client, msg.ReferencedMessage), o.since(msg.ChannelID)))
+	}
+	msgs = stripReasoning(after(msgs, o.since(msg.ChannelID)))
+
+	// NOTE:
+	// System messages like joins and pins carry no conversation
+	msgs = slices.DeleteFunc(msgs, func(m discord.Message) bool {
+		return m.Author.System || (m.Content == "" && len(m.Attachments) == 0)
+	})
+	return mergeAuthors(msgs)
+}
+
+// Turns a discord message into a chat message, attaching up to images images
+func (o *Ollama) chatMessage(ctx context.Context, client *bot.Client, msg discord.Message, images *int) OllamaChatMessage {
+	content := resolveMentions(client, msg)
+
+	// Tag bot messages with the assistant role, and normal user messages the user role
+	if msg.Author.Bot {
+		return OllamaChatMessage{
+			Role:    OllamaChatMessageRoleAssistant,
+			Content: content,
+		}
+	}
+
+	chatMsg := OllamaChatMessage{
+		Role:    OllamaChatMessageRoleUser,
+		Content: fmt.Sprintf("'%s' says:\n%s", authorName(client, msg), content),
+	}
+	if *images > 0 {
+		chatMsg.Images = o.images(ctx, imageAttachments(msg), *images)
+		*images -= len(chatMsg.Images)
+	}
+	return chatMsg
+}
+
+// Drops the oldest messages exceeding the token budget from a newest first list
+func trimContext(msgs []OllamaChatMessage, budget int) []OllamaChatMessage {
+	if budget <= 0 {
+		return msgs
+	}
+
+	used := 0
+	for i, msg := range msgs {
+		used += countTokens(msg)
+		if used > budget {
+			return msgs[:i]
+		}
+	}
+	return msgs
+}
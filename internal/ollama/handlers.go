@@ -1,29 +1,161 @@
 package ollama
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
 )
 
 var RegexpDiscordGroupMention = regexp.MustCompile("(?:@everyone)|(?:@here)|(?:<@&[0-9]{1,32}>)")
 
+const (
+	MessageMaxLength = 2000
+	// NOTE:
+	// Discord allows roughly 5 message edits per 5 seconds in a channel
+	StreamEditInterval = 1500 * time.Millisecond
+	StreamPlaceholder  = "💭"
+	ImageUnsupported   = "sorry, chat can't see images with the current model 🙈\ntry describing it in words instead."
+	DefaultTemperature = 1.5
+	RateLimited        = "slow down, chat needs a breather ⏳\ntry again in %s."
+	RateLimitedEmoji   = "⏳"
+	Busy               = "chat is swamped right now 😵\ntry again in a bit."
+)
+
+// Turns a chat result into the text to reply with
+func (o *Ollama) answer(res *OllamaChatResponse, err error, author discord.User) string {
+	if err != nil {
+		o.logger.Error("Failed to chat", slog.Any("error", err))
+		return "hey, chat is currently out touching grass 🌱\nthe AI backend isn't responding right now — try again in a bit."
+	}
+	if strings.TrimSpace(res.Message.Content) == "" {
+		return "hey, chat stared into the void and the void said nothing back."
+	}
+
+	// NOTE:
+	// Let's avoid pinging groups
+	return RegexpDiscordGroupMention.ReplaceAllString(res.Message.Content, author.Mention())
+}
+
+// Truncates partial content so it fits in a single message while streaming
+func preview(content string) string {
+	runes := []rune(content)
+	if len(runes) <= MessageMaxLength {
+		return content
+	}
+	return string(runes[:MessageMaxLength-1]) + "…"
+}
+
+// The part of the rest client needed to send and edit replies
+type messageClient interface {
+	CreateMessage(channelID snowflake.ID, messageCreate discord.MessageCreate, opts ...rest.RequestOpt) (*discord.Message, error)
+	UpdateMessage(channelID snowflake.ID, messageID snowflake.ID, messageUpdate discord.MessageUpdate, opts ...rest.RequestOpt) (*discord.Message, error)
+}
+
+// Replies with a placeholder message and edits it as the answer is generated
+func (o *Ollama) stream(ctx context.Context, client messageClient, reply discord.Message, chat OllamaChat, tc ToolContext) {
+	author := reply.Author
+
+	msg, err := client.CreateMessage(reply.ChannelID, discord.NewMessageCreate().WithContent(StreamPlaceholder).WithMessageReferenceByID(reply.ID).WithAllowedMentions(o.allowedMentions()))
+	if err != nil {
+		o.logger.Error("Send message failed", slog.Any("error", err))
+		return
+	}
+
+	var content strings.Builder
+	edited := time.Now()
+	res, err := o.complete(ctx, chat, tc, func(chunk *OllamaChatResponse) error {
+		// NOTE:
+		// The final edit is done once the stream is complete.
+		// Every turn of the tool loop ends with a done chunk too, so the preview starts over with the next turn
+		if chunk.Done {
+			content.Reset()
+			return nil
+		}
+		content.WriteString(chunk.Message.Content)
+		if time.Since(edited) < StreamEditInterval || strings.TrimSpace(content.String()) == "" {
+			return nil
+		}
+		edited = time.Now()
+
+		partial := RegexpDiscordGroupMention.ReplaceAllString(content.String(), author.Mention())
+		_, err := client.UpdateMessage(reply.ChannelID, msg.ID, discord.NewMessageUpdate().WithContent(preview(partial)).WithAllowedMentions(o.allowedMentions()))
+		if err != nil {
+			o.logger.Warn("Failed to update streamed message", slog.Any("error", err))
+		}
+		return nil
+	})
+
+	// NOTE:
+	// The placeholder becomes the first message, whatever doesn't fit is sent as replies to it
+	msgs := o.withReasoning(res, reply.ID, o.Messages(o.answer(res, err, author)))
+	_, err = client.UpdateMessage(reply.ChannelID, msg.ID, discord.MessageUpdate{
+		Content:         &msgs[0].Content,
+		Components:      &msgs[0].Components,
+		Files:           msgs[0].Files,
+		AllowedMentions: msgs[0].AllowedMentions,
+	})
+	if err != nil {
+		o.logger.Error("Update message failed", slog.Any("error", err))
+		return
+	}
+
+	err = o.send(client, reply.ChannelID, msg.ID, msgs[1:])
+	if err != nil {
+		o.logger.Error("Send message failed", slog.Any("error", err))
+	} else {
+		o.logger.Info("Message sent")
+	}
+}
+
 func (o *Ollama) onMessageCreate(e *events.MessageCreate) {
-	if e.Message.Author.System || e.Message.Author.ID == e.Client().ID() {
+	if e.Message.Author.System {
+		return
+	}
+	// NOTE:
+	// Every message counts towards the summary of the channel, the answers of the bot too
+	o.seen(e.ChannelID, e.Message.ID)
+	if e.Message.Author.ID == e.Client().ID() {
 		return
 	}
 
-	// Only consider messages containing "hey chat" and responses to this bot
-	if !strings.Contains(strings.ToLower(e.Message.Content), "hey chat") {
-		// Does the message reference this bot?
-		if !(e.Message.ReferencedMessage != nil && e.Message.ReferencedMessage.Author.ID == e.Client().ID()) {
-			return
+	// NOTE:
+	// Direct messages have no guild, and fall back to the default config
+	var guildID snowflake.ID
+	if e.GuildID != nil {
+		guildID = *e.GuildID
+	}
+
+	if !o.trigger(guildID, e.ChannelID).matches(e.Message, e.Client().ID()) {
+		return
+	}
+
+	// NOTE:
+	// A reaction is less noisy than a reply for someone spamming
+	if wait := o.limits.allow(e.Message.Author.ID, e.ChannelID, guildID); wait > 0 {
+		o.logger.Debug("Rate limited chat message", slog.Any("user_id", e.Message.Author.ID), slog.Duration("wait", wait))
+		err := e.Client().Rest.AddReaction(e.ChannelID, e.Message.ID, RateLimitedEmoji)
+		if err != nil {
+			o.logger.Warn("Failed to add reaction", slog.Any("error", err))
+		}
+		return
+	}
+
+	vision := o.vision(guildID, e.ChannelID)
+	if !vision && len(imageAttachments(e.Message)) > 0 {
+		_, err := e.Client().Rest.CreateMessage(e.ChannelID, discord.NewMessageCreate().WithContent(ImageUnsupported).WithMessageReferenceByID(e.Message.ID))
+		if err != nil {
+			o.logger.Error("Send message failed", slog.Any("error", err))
 		}
+		return
 	}
 
 	err := e.Client().Rest.SendTyping(e.ChannelID)
@@ -31,49 +163,50 @@ func (o *Ollama) onMessageCreate(e *events.MessageCreate) {
 		o.logger.Warn("Could not complete channel typing", slog.Any("error", err))
 	}
 
+	// NOTE:
+	// Message events don't carry a context, so the request is only bound by the client timeout
+	ctx := context.Background()
+
 	// NOTE:
 	// We build the message list in a reverse order to make it easier to
 	// append referenced messages. After building the list, we reverse it before sending it
 	// to the Ollama API.
 	messages := make([]OllamaChatMessage, 0, 30)
 
+	// NOTE:
+	// Images are attached newest first, so the current message gets priority
+	images := 0
+	if vision {
+		images = o.maxImages()
+	}
+
 	// Build current message context
-	messages = append(messages, OllamaChatMessage{
+	current := OllamaChatMessage{
 		Role:    OllamaChatMessageRoleUser,
-		Content: fmt.Sprintf("'%s' says:\n%s", e.Message.Author.Mention(), e.Message.Content),
-	})
-
-	// Build previous bot and user context (max 100 messages)
-	ref := e.Message.ReferencedMessage
-	for range 100 {
-		// If no message is referenced, drop out of loop
-		if ref == nil {
-			break
-		}
-
-		// Retrieve message if in cache to get more complete data
-		if cachedRef, ok := e.Client().Caches.Message(ref.ChannelID, ref.ID); ok {
-			ref = &cachedRef
-		}
+		Content: fmt.Sprintf("'%s' says:\n%s", authorName(e.Client(), e.Message), resolveMentions(e.Client(), e.Message)),
+	}
+	if images > 0 {
+		current.Images = o.images(ctx, imageAttachments(e.Message), images)
+		images -= len(current.Images)
+	}
+	messages = append(messages, current)
 
-		// Tag bot messages with the assistant role, and normal user messages the user role
-		if ref.Author.Bot {
-			messages = append(messages, OllamaChatMessage{
-				Role:    OllamaChatMessageRoleAssistant,
-				Content: ref.Content,
-			})
-		} else {
-			messages = append(messages, OllamaChatMessage{
-				Role:    OllamaChatMessageRoleUser,
-				Content: fmt.Sprintf("'%s' says:\n%s", ref.Author.Mention(), ref.Content),
-			})
-		}
+	// Build previous bot and user context
+	contextCfg := o.contextConfig(guildID, e.ChannelID)
+	conversation := o.conversation(e.Client(), e.Message, contextCfg)
+	history := make([]OllamaChatMessage, 0, len(conversation))
+	for _, msg := range conversation {
+		history = append(history, o.chatMessage(ctx, e.Client(), msg, &images))
+	}
+	messages = append(messages, trimContext(history, contextCfg.TokenBudget)...)
 
-		ref = ref.ReferencedMessage
+	// Build long-term memory context
+	if memory, ok := o.memoryPrompt(e.ChannelID); ok {
+		messages = append(messages, memory)
 	}
 
 	// Build system context
-	prompts := o.prompts(*e.GuildID, e.ChannelID)
+	prompts := o.prompts(guildID, e.ChannelID)
 	slices.Reverse(prompts)
 	messages = append(messages, prompts...)
 
@@ -81,29 +214,48 @@ func (o *Ollama) onMessageCreate(e *events.MessageCreate) {
 	// Now we reverse the list so it's in the correct order
 	slices.Reverse(messages)
 
-	// Do the chat
-	res, err := o.Chat(OllamaChat{
-		Model:    o.model(*e.Message.GuildID, e.ChannelID),
+	chat := OllamaChat{
+		Model:    o.model(guildID, e.ChannelID),
 		Messages: messages,
-		Options: OllamaChatOptions{
-			Temperature: 1.5,
-		},
-		Stream: false,
-	})
+		Stream:   false,
+	}
+	o.applyOptions(&chat, guildID, e.ChannelID)
+	o.fit(&chat, o.modelConfig(guildID, e.ChannelID).NumCtx)
 
-	var answer string
+	// NOTE:
+	// The slot is taken after the images are downloaded, so a slow attachment host doesn't hold it
+	release, err := o.limits.acquire(ctx)
 	if err != nil {
-		o.logger.Error("Failed to chat", slog.Any("error", err))
-		answer = "hey, chat is currently out touching grass 🌱\nthe AI backend isn't responding right now — try again in a bit."
-	} else if res.Message.Content == "" {
-		answer = "hey, chat stared into the void and the void said nothing back."
-	} else {
-		// NOTE:
-		// Let's avoid pinging groups
-		answer = RegexpDiscordGroupMention.ReplaceAllString(res.Message.Content, e.Message.Author.Mention())
+		_, err = e.Client().Rest.CreateMessage(e.ChannelID, discord.NewMessageCreate().WithContent(Busy).WithMessageReferenceByID(e.Message.ID))
+		if err != nil {
+			o.logger.Error("Send message failed", slog.Any("error", err))
+		}
+		return
+	}
+	defer release()
+
+	tc := ToolContext{
+		Client:    e.Client(),
+		GuildID:   e.GuildID,
+		ChannelID: e.ChannelID,
+		User:      e.Message.Author,
+		Member:    e.Message.Member,
 	}
 
-	_, err = e.Client().Rest.CreateMessage(e.ChannelID, discord.NewMessageCreate().WithContent(answer).WithMessageReferenceByID(e.Message.ID))
+	// NOTE:
+	// The summary is updated after answering, so the reply is not held back by it
+	defer o.remember(e.Client(), guildID, e.ChannelID)
+
+	if o.cfg.Stream {
+		o.stream(ctx, e.Client().Rest, e.Message, chat, tc)
+		return
+	}
+
+	// Do the chat
+	res, err := o.complete(ctx, chat, tc, nil)
+	answer := o.answer(res, err, e.Message.Author)
+
+	err = o.send(e.Client().Rest, e.ChannelID, e.Message.ID, o.withReasoning(res, e.Message.ID, o.Messages(answer)))
 	if err != nil {
 		o.logger.Error("Send message failed", slog.Any("error", err))
 	} else {
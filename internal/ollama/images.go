@@ -0,0 +1,113 @@
+package ollama
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+)
+
+const (
+	DefaultMaxImages     = 4
+	DefaultMaxImageSize  = 8 << 20          // 8 MiB
+	ImageDownloadTimeout = 15 * time.Second // max time of a single image download
+)
+
+var imageClient = &http.Client{}
+
+func imageAttachments(msg discord.Message) []discord.Attachment {
+	attachments := make([]discord.Attachment, 0, len(msg.Attachments))
+	for _, attachment := range msg.Attachments {
+		if attachment.ContentType != nil && strings.HasPrefix(*attachment.ContentType, "image/") {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
+
+func (o *Ollama) maxImages() int {
+	if o.cfg.MaxImages > 0 {
+		return o.cfg.MaxImages
+	}
+	return DefaultMaxImages
+}
+
+func (o *Ollama) maxImageSize() int {
+	if o.cfg.MaxImageSize > 0 {
+		return o.cfg.MaxImageSize
+	}
+	return DefaultMaxImageSize
+}
+
+func (o *Ollama) download(ctx context.Context, url string, size int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, ImageDownloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-successful status '%s'", resp.Status)
+	}
+
+	// NOTE:
+	// Don't trust the reported attachment size, read at most one byte past the limit
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > size {
+		return nil, fmt.Errorf("image is larger than %d bytes", size)
+	}
+
+	return data, nil
+}
+
+// Downloads up to max images from the attachments as base64, all at once.
+// Images that are too large or fail to download are skipped.
+func (o *Ollama) images(ctx context.Context, attachments []discord.Attachment, max int) []string {
+	size := o.maxImageSize()
+	attachments = slices.DeleteFunc(slices.Clone(attachments), func(attachment discord.Attachment) bool {
+		if attachment.Size > size {
+			o.logger.Debug("Skipping large image", slog.String("filename", attachment.Filename), slog.Int("size", attachment.Size))
+			return true
+		}
+		return false
+	})
+	attachments = attachments[:min(len(attachments), max)]
+
+	downloaded := make([]string, len(attachments))
+	var wg sync.WaitGroup
+	for i, attachment := range attachments {
+		wg.Go(func() {
+			data, err := o.download(ctx, attachment.URL, size)
+			if err != nil {
+				o.logger.Warn("Failed to download image", slog.String("filename", attachment.Filename), slog.Any("error", err))
+				return
+			}
+			downloaded[i] = base64.StdEncoding.EncodeToString(data)
+		})
+	}
+	wg.Wait()
+
+	// NOTE:
+	// Failed downloads are left out, keeping the order of the attachments
+	return slices.DeleteFunc(downloaded, func(image string) bool {
+		return image == ""
+	})
+}
@@ -0,0 +1,168 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	DefaultConcurrency = 2
+	DefaultQueue       = 10
+	// NOTE:
+	// Buckets are pruned once there are this many, refilled buckets are the same as no bucket
+	maxBuckets = 1024
+)
+
+var (
+	ErrBusy        = errors.New("too many chat requests waiting")
+	ErrRateLimited = errors.New("rate limited")
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// A token bucket per key
+type limiter struct {
+	every   time.Duration
+	burst   float64
+	buckets map[snowflake.ID]*bucket
+}
+
+func newLimiter(cfg *config.OllamaRateLimitConfig) *limiter {
+	if cfg == nil || cfg.Every <= 0 {
+		return nil
+	}
+	return &limiter{
+		every:   cfg.Every,
+		burst:   float64(max(cfg.Burst, 1)),
+		buckets: make(map[snowflake.ID]*bucket),
+	}
+}
+
+// Refills the bucket of the key and returns it
+func (l *limiter) bucket(key snowflake.ID, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+float64(now.Sub(b.last))/float64(l.every))
+	b.last = now
+	return b
+}
+
+func (l *limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+float64(now.Sub(b.last))/float64(l.every) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Time until the bucket has a token again
+func (l *limiter) wait(b *bucket) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(l.every))
+}
+
+// Rate limits and concurrency cap of the requests to ollama
+type limits struct {
+	m        sync.Mutex
+	limiters [3]*limiter // user, channel and guild
+	slots    chan struct{}
+	waiting  atomic.Int32
+	queue    int32
+}
+
+func newLimits(cfg *config.OllamaLimitsConfig) *limits {
+	if cfg == nil {
+		cfg = &config.OllamaLimitsConfig{}
+	}
+
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	queue := cfg.Queue
+	if queue <= 0 {
+		queue = DefaultQueue
+	}
+
+	return &limits{
+		limiters: [3]*limiter{newLimiter(cfg.User), newLimiter(cfg.Channel), newLimiter(cfg.Guild)},
+		slots:    make(chan struct{}, concurrency),
+		queue:    int32(queue),
+	}
+}
+
+// Takes a token for the user, channel and guild.
+// If any of them is out of tokens none are taken, and the time to wait is returned.
+func (l *limits) allow(userID snowflake.ID, channelID snowflake.ID, guildID snowflake.ID) time.Duration {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	now := time.Now()
+	keys := [3]snowflake.ID{userID, channelID, guildID}
+	var buckets [3]*bucket
+	var wait time.Duration
+	for i, limiter := range l.limiters {
+		// NOTE:
+		// Direct messages have no guild to limit
+		if limiter == nil || keys[i] == 0 {
+			continue
+		}
+		buckets[i] = limiter.bucket(keys[i], now)
+		wait = max(wait, limiter.wait(buckets[i]))
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	for _, b := range buckets {
+		if b != nil {
+			b.tokens--
+		}
+	}
+	return 0
+}
+
+// Waits for a free request slot, unless the queue is full or ctx is done.
+// The returned function gives the slot back.
+func (l *limits) acquire(ctx context.Context) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+
+	if l.waiting.Add(1) > l.queue {
+		l.waiting.Add(-1)
+		return nil, ErrBusy
+	}
+	defer l.waiting.Add(-1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *limits) release() {
+	<-l.slots
+}
@@ -0,0 +1,32 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+)
+
+func TestLimitsAcquireCancelled(t *testing.T) {
+	l := newLimits(&config.OllamaLimitsConfig{Concurrency: 1, Queue: 1})
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to end with the context, got %v", err)
+	}
+
+	// NOTE:
+	// The cancelled wait should leave its place in the queue
+	if got := l.waiting.Load(); got != 0 {
+		t.Fatalf("expected no one waiting, got %d", got)
+	}
+}
@@ -0,0 +1,235 @@
+package ollama
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	DefaultMemoryThreshold = 40
+	DefaultMemoryKeep      = 20
+)
+
+const memorySystemPrompt = `You maintain the long-term memory of a Discord chat bot.
+You are given the current summary of a channel's conversation and the messages that followed it.
+Write an updated summary that keeps the important facts, ongoing topics, decisions and who said what.
+Leave out greetings and small talk. Write at most 300 words of plain text without headings.`
+
+// A Memory is the rolling summary of the older conversation in a channel
+type Memory struct {
+	Summary   string       `json:"summary"`
+	Until     snowflake.ID `json:"until"` // newest message included in the summary
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// Messages seen in a channel that are not in its summary yet
+type pendingMessages struct {
+	first snowflake.ID // oldest message seen, where the summary of a new channel starts
+	count int
+}
+
+// Counts the messages seen in every channel, so the history is only fetched once enough piled up.
+// The counts are only held in memory, after a restart the summary catches up a bit later.
+type unsummarized struct {
+	m    sync.Mutex
+	data map[snowflake.ID]pendingMessages
+}
+
+func (u *unsummarized) add(channelID snowflake.ID, messageID snowflake.ID) {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	pending := u.data[channelID]
+	if pending.first == 0 {
+		pending.first = messageID
+	}
+	pending.count++
+	u.data[channelID] = pending
+}
+
+func (u *unsummarized) get(channelID snowflake.ID) pendingMessages {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	return u.data[channelID]
+}
+
+func (u *unsummarized) reset(channelID snowflake.ID) {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	delete(u.data, channelID)
+}
+
+// Takes the summarized messages off the count, leaving those seen meanwhile
+func (u *unsummarized) done(channelID snowflake.ID, count int) {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	pending := u.data[channelID]
+	pending.count = max(pending.count-count, 0)
+	u.data[channelID] = pending
+}
+
+func (o *Ollama) memoryEnabled() bool {
+	return o.cfg.Memory != nil && o.cfg.Memory.Enabled
+}
+
+// Memory returns the summary of the channel, if any
+func (o *Ollama) Memory(channelID snowflake.ID) (Memory, bool) {
+	return o.memories.Get(channelID)
+}
+
+// ResetMemory forgets the summary of the channel, a new one starts with the next message
+func (o *Ollama) ResetMemory(channelID snowflake.ID) error {
+	err := o.memories.Delete(channelID)
+	if err != nil {
+		return err
+	}
+	o.unsummarized.reset(channelID)
+	return nil
+}
+
+// Wraps the summary of the channel in a system message
+func (o *Ollama) memoryPrompt(channelID snowflake.ID) (OllamaChatMessage, bool) {
+	if !o.memoryEnabled() {
+		return OllamaChatMessage{}, false
+	}
+
+	mem, ok := o.memories.Get(channelID)
+	if !ok || mem.Summary == "" {
+		return OllamaChatMessage{}, false
+	}
+
+	return OllamaChatMessage{
+		Role:    OllamaChatMessageRoleSystem,
+		Content: "Summary of the earlier conversation in this channel:\n" + mem.Summary,
+	}, true
+}
+
+// Counts a message towards the next summary of the channel
+func (o *Ollama) seen(channelID snowflake.ID, messageID snowflake.ID) {
+	if o.memoryEnabled() {
+		o.unsummarized.add(channelID, messageID)
+	}
+}
+
+// Starts updating the summary of the channel in the background once enough messages were seen,
+// unless an update is already running
+func (o *Ollama) remember(client *bot.Client, guildID snowflake.ID, channelID snowflake.ID) {
+	if !o.memoryEnabled() {
+		return
+	}
+	threshold := cmp.Or(o.cfg.Memory.Threshold, DefaultMemoryThreshold)
+	keep := cmp.Or(o.cfg.Memory.Keep, DefaultMemoryKeep)
+	pending := o.unsummarized.get(channelID)
+	if pending.count < threshold+keep {
+		return
+	}
+	if _, running := o.remembering.LoadOrStore(channelID, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer o.remembering.Delete(channelID)
+
+		// NOTE:
+		// The update runs after the message was answered, so it gets the time of a request of its own
+		ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(o.cfg.Timeout, DefaultTimeout))
+		defer cancel()
+
+		err := o.summarize(ctx, client, guildID, channelID, pending.first)
+		if err != nil {
+			o.logger.Warn("Failed to update channel memory", slog.Any("channel_id", channelID), slog.Any("error", err))
+		}
+	}()
+}
+
+// Folds the messages that followed the summary into it, except the most recent ones.
+// A channel without a summary starts at the first message seen.
+func (o *Ollama) summarize(ctx context.Context, client *bot.Client, guildID snowflake.ID, channelID snowflake.ID, first snowflake.ID) error {
+	threshold := cmp.Or(o.cfg.Memory.Threshold, DefaultMemoryThreshold)
+	keep := cmp.Or(o.cfg.Memory.Keep, DefaultMemoryKeep)
+
+	// NOTE:
+	// Discord returns the oldest messages after the given one, so a backlog is summarized in order
+	mem, _ := o.memories.Get(channelID)
+	after := max(mem.Until, o.since(channelID))
+	if after == 0 {
+		after = first - 1
+	}
+	msgs, err := client.Rest.GetMessages(channelID, 0, 0, after, min(threshold+keep, MaxContextMessages), rest.WithCtx(ctx))
+	if err != nil {
+		return err
+	}
+	if len(msgs) < threshold+keep {
+		// NOTE:
+		// Deleted messages were counted too, count what is actually there instead
+		o.unsummarized.done(channelID, o.unsummarized.get(channelID).count-len(msgs))
+		return nil
+	}
+
+	// NOTE:
+	// Oldest first, and the most recent messages are left out as they are sent to the model as is
+	slices.SortFunc(msgs, func(a, b discord.Message) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	summarized := len(msgs) - keep
+	msgs = stripReasoning(msgs[:summarized])
+
+	var prompt strings.Builder
+	if mem.Summary != "" {
+		fmt.Fprintf(&prompt, "Current summary:\n%s\n\n", mem.Summary)
+	}
+	prompt.WriteString("New messages:\n")
+	for _, msg := range msgs {
+		if msg.Author.System || msg.Content == "" {
+			continue
+		}
+		fmt.Fprintf(&prompt, "%s: %s\n", authorName(client, msg), resolveMentions(client, msg))
+	}
+
+	model := o.cfg.Memory.Model
+	if model == "" {
+		model = o.model(guildID, channelID)
+	}
+
+	release, err := o.limits.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	res, err := o.Generate(ctx, OllamaGenerate{
+		Model:  model,
+		System: memorySystemPrompt,
+		Prompt: prompt.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	o.logger.Debug("Updated channel memory", slog.Any("channel_id", channelID), slog.Int("messages", len(msgs)))
+	err = o.memories.Set(channelID, Memory{
+		Summary:   strings.TrimSpace(res.Response),
+		Until:     msgs[len(msgs)-1].ID,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	o.unsummarized.done(channelID, summarized)
+	return nil
+}
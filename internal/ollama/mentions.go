@@ -0,0 +1,77 @@
+package ollama
+
+import (
+	"regexp"
+	"slices"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+var RegexpDiscordUserMention = regexp.MustCompile("<@!?([0-9]{1,32})>")
+
+type MentionPolicy = string
+
+const (
+	MentionPolicyReply MentionPolicy = "reply" // only the user being replied to is pinged
+	MentionPolicyNone  MentionPolicy = "none"  // nobody is pinged
+)
+
+// The mentions allowed in messages containing model output.
+// Whatever the model writes, it can never ping anyone else.
+func (o *Ollama) allowedMentions() *discord.AllowedMentions {
+	return &discord.AllowedMentions{
+		Parse:       []discord.AllowedMentionType{},
+		RepliedUser: o.cfg.Mentions != MentionPolicyNone,
+	}
+}
+
+// Get the name of the user as shown in the guild.
+// Members are only cached with the guild members intent, otherwise the global name is used.
+func displayName(client *bot.Client, guildID *snowflake.ID, user discord.User) string {
+	if guildID != nil {
+		if member, ok := client.Caches.Member(*guildID, user.ID); ok {
+			return member.EffectiveName()
+		}
+	}
+	return user.EffectiveName()
+}
+
+// Get the name of the author as shown in the guild, using the member sent along with the message
+func authorName(client *bot.Client, msg discord.Message) string {
+	if msg.Member != nil {
+		if msg.Member.Nick != nil {
+			return *msg.Member.Nick
+		}
+		return msg.Author.EffectiveName()
+	}
+	return displayName(client, msg.GuildID, msg.Author)
+}
+
+// Replaces the user mentions in the message with display names, so the model sees names rather than IDs
+//
+// NOTE:
+// Discord sends the members of the mentioned users too, but they are dropped when decoding the mentions,
+// so only the author is resolved without the cache
+func resolveMentions(client *bot.Client, msg discord.Message) string {
+	return RegexpDiscordUserMention.ReplaceAllStringFunc(msg.Content, func(mention string) string {
+		id, err := snowflake.Parse(RegexpDiscordUserMention.FindStringSubmatch(mention)[1])
+		if err != nil {
+			return mention
+		}
+
+		if id == msg.Author.ID {
+			return "@" + authorName(client, msg)
+		}
+		if i := slices.IndexFunc(msg.Mentions, func(user discord.User) bool { return user.ID == id }); i >= 0 {
+			return "@" + displayName(client, msg.GuildID, msg.Mentions[i])
+		}
+		if msg.GuildID != nil {
+			if member, ok := client.Caches.Member(*msg.GuildID, id); ok {
+				return "@" + member.EffectiveName()
+			}
+		}
+		return mention
+	})
+}
@@ -0,0 +1,135 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	TagsPath = "/api/tags"
+	PsPath   = "/api/ps"
+	PullPath = "/api/pull"
+	// NOTE:
+	// Large models take a while to download, so pulls get their own limit instead of the request timeout
+	PullTimeout = time.Hour
+)
+
+// structs
+// model listing of the tags and ps endpoints of ollama
+// https://docs.ollama.com/api/tags
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt time.Time          `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+	// only set for running models
+	SizeVRAM  int64     `json:"size_vram,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+type OllamaModelDetails struct {
+	Format            string `json:"format"`
+	Family            string `json:"family"`
+	ParameterSize     string `json:"parameter_size"`
+	QuantizationLevel string `json:"quantization_level"`
+}
+
+type OllamaModelsResponse struct {
+	Models []OllamaModel `json:"models"`
+}
+
+// message model for the pull endpoint of ollama
+// https://docs.ollama.com/api/pull
+type OllamaPull struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+}
+
+type OllamaPullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Models lists the models installed on the ollama server
+func (o *Ollama) Models(ctx context.Context) ([]OllamaModel, error) {
+	var resp OllamaModelsResponse
+	err := o.client.Get(ctx, TagsPath, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Models, nil
+}
+
+// RunningModels lists the models loaded in memory on the ollama server
+func (o *Ollama) RunningModels(ctx context.Context) ([]OllamaModel, error) {
+	var resp OllamaModelsResponse
+	err := o.client.Get(ctx, PsPath, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Models, nil
+}
+
+// Pull downloads the model to the ollama server, calling onProgress for every status update
+func (o *Ollama) Pull(ctx context.Context, model string, onProgress func(progress OllamaPullProgress) error) error {
+	o.logger.Info("Pulling model", slog.String("model", model))
+
+	ctx, cancel := context.WithTimeout(ctx, PullTimeout)
+	defer cancel()
+
+	client := *o.client
+	client.HTTP = &http.Client{}
+	resp, err := client.Post(ctx, PullPath, OllamaPull{
+		Model:  model,
+		Stream: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// NOTE:
+	// Like chats, the progress is streamed one JSON object per line
+	jsonDecoder := json.NewDecoder(resp.Body)
+	for {
+		var progress OllamaPullProgress
+		err = jsonDecoder.Decode(&progress)
+		if err != nil {
+			if err == io.EOF {
+				return ErrStreamIncomplete
+			}
+			return err
+		}
+		if progress.Error != "" {
+			return errors.New(progress.Error)
+		}
+
+		err = onProgress(progress)
+		if err != nil {
+			return err
+		}
+
+		if progress.Status == "success" {
+			return nil
+		}
+	}
+}
+
+// KeepAlive loads the model and keeps it in memory for the duration, a duration of 0 unloads it
+func (o *Ollama) KeepAlive(ctx context.Context, model string, keepAlive time.Duration) error {
+	_, err := o.Generate(ctx, OllamaGenerate{
+		Model:     model,
+		KeepAlive: keepAlive.String(),
+	})
+	return err
+}
@@ -1,15 +1,15 @@
 package ollama
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"log/slog"
-	"net/http"
-	"net/url"
+	"regexp"
 	"slices"
+	"sync"
 
 	"github.com/Akvanvig/roboto-go/internal/config"
+	"github.com/Akvanvig/roboto-go/internal/store"
 	"github.com/disgoorg/disgo/bot"
 	"github.com/disgoorg/snowflake/v2"
 )
@@ -21,20 +21,22 @@ type OllamaChat struct {
 	Model       string              `json:"model"`    // required
 	Messages    []OllamaChatMessage `json:"messages"` // required
 	Tools       []OllamaChatTools   `json:"tools,omitzero"`
-	Format      string              `json:"format,omitempty"`
+	Format      json.RawMessage     `json:"format,omitempty"` // FormatJSON or a JSON schema the answer must match
 	Options     OllamaChatOptions   `json:"options,omitzero"`
 	Stream      bool                `json:"stream"`
-	Think       string              `json:"think,omitempty"` // high, medium, low
+	Think       OllamaThink         `json:"think,omitempty"`
 	KeepAlive   string              `json:"keep_alive,omitempty"`
 	Logprobs    bool                `json:"logprobs,omitempty"`
 	TopLogprobs int                 `json:"top_logprobs,omitempty"`
 }
 
 type OllamaChatMessage struct {
-	Role      OllamaChatMessageRole `json:"role"`             // required "system","user","assistant" or "tool"
-	Content   string                `json:"content"`          // required
-	Images    []string              `json:"images,omitempty"` // base64-encoded image content
+	Role      OllamaChatMessageRole `json:"role"`               // required "system","user","assistant" or "tool"
+	Content   string                `json:"content"`            // required
+	Thinking  string                `json:"thinking,omitempty"` // reasoning of thinking models, before the content
+	Images    []string              `json:"images,omitempty"`   // base64-encoded image content
 	ToolCalls []OllamaChatToolCalls `json:"tool_calls,omitempty"`
+	ToolName  string                `json:"tool_name,omitempty"` // name of the tool a "tool" message is the result of
 }
 
 type OllamaChatMessageRole = string
@@ -46,23 +48,38 @@ const (
 	OllamaChatMessageRoleTool      OllamaChatMessageRole = "tool"
 )
 
-// TODO
+// tool definition offered to the model
+// https://docs.ollama.com/capabilities/tool-calling
 type OllamaChatTools struct {
+	Type     string                 `json:"type"` // always "function"
+	Function OllamaChatToolFunction `json:"function"`
 }
 
-// TODO
+type OllamaChatToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // JSON schema of the arguments
+}
+
+// tool call requested by the model
 type OllamaChatToolCalls struct {
+	Function OllamaChatToolCallFunction `json:"function"`
+}
+
+type OllamaChatToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
 }
 
 type OllamaChatOptions struct {
-	Seed        int     `json:"seed,omitempty"`
-	Temperature float64 `json:"temperature,omitempty"`
-	TopK        int     `json:"top_k,omitempty"`
-	TopP        float64 `json:"top_p,omitempty"`
-	MinP        float64 `json:"min_p,omitempty"`
-	Stop        string  `json:"stop,omitempty"`
-	NumCtx      int     `json:"num_ctx,omitempty"`
-	NumPredict  int     `json:"num_predict,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`        // a pointer, since 0 is a valid seed
+	Temperature *float64 `json:"temperature,omitempty"` // a pointer, since 0 is a valid temperature
+	TopK        int      `json:"top_k,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"` // a pointer, since 0 is a valid top p
+	MinP        *float64 `json:"min_p,omitempty"` // a pointer, since 0 is a valid min p
+	Stop        []string `json:"stop,omitempty"`
+	NumCtx      int      `json:"num_ctx,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"` // -1 is unlimited
 }
 
 type OllamaChatResponse struct {
@@ -78,34 +95,82 @@ type OllamaChatResponse struct {
 	EvalCount          int                  `json:"eval_count"`
 	EvalDuration       int                  `json:"eval_duration"`
 	Logprobs           []OllamaChatLogProbs `json:"logprobs"`
+	Error              string               `json:"error,omitempty"` // set when the stream fails partway, like running out of memory
 }
 
 // TODO
 type OllamaChatLogProbs struct {
 }
 
+// message model for the generate endpoint of ollama
+// https://docs.ollama.com/api/generate
+type OllamaGenerate struct {
+	Model     string            `json:"model"` // required
+	Prompt    string            `json:"prompt"`
+	System    string            `json:"system,omitempty"`
+	Options   OllamaChatOptions `json:"options,omitzero"`
+	Stream    bool              `json:"stream"`
+	KeepAlive string            `json:"keep_alive,omitempty"`
+}
+
+type OllamaGenerateResponse struct {
+	Model           string `json:"model"`
+	CreatedAt       string `json:"created_at"`
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	TotalDuration   int    `json:"total_duration"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+}
+
 // data for connecting to ollama server
 type Ollama struct {
-	logger *slog.Logger
-	cfg    *config.OllamaConfig
+	logger   *slog.Logger
+	cfg      *config.OllamaConfig
+	client   *Client
+	backends map[string]backend
+	memories *store.Store[snowflake.ID, Memory]
+	channels *store.Store[snowflake.ID, ChannelState]
+	// prompts set with commands, and who changed them
+	storedPrompts *store.Store[snowflake.ID, StoredPrompt]
+	promptAudit   *store.Log[PromptChange]
+	promptsM      sync.Mutex // serializes changes to the stored prompts, so the server limit holds
+	patterns      map[string]*regexp.Regexp
+	limits        *limits
+	remembering   sync.Map
+	unsummarized  *unsummarized
+	reasonings    *reasonings
+	// Tools offered to the model when tool calling is enabled
+	Tools *ToolRegistry
 }
 
-// Get system model
-func (o *Ollama) model(guildID snowflake.ID, channelID snowflake.ID) string {
-	if cfg := o.cfg.ChannelPrompts[channelID]; cfg.Model != "" {
-		return cfg.Model
+// Get the prompt config that decides the model
+func (o *Ollama) modelConfig(guildID snowflake.ID, channelID snowflake.ID) config.OllamaSystemPromptConfig {
+	if cfg := o.channelPrompt(channelID); cfg.Model != "" {
+		return cfg
 	}
-	if cfg := o.cfg.ServerPrompts[guildID]; cfg.Model != "" {
-		return cfg.Model
+	if cfg := o.serverPrompt(guildID); cfg.Model != "" {
+		return cfg
 	}
-	return o.cfg.DefaultPrompt.Model
+	return o.cfg.DefaultPrompt
+}
+
+// Get system model
+func (o *Ollama) model(guildID snowflake.ID, channelID snowflake.ID) string {
+	return o.modelConfig(guildID, channelID).Model
+}
+
+// Whether the system model accepts images
+func (o *Ollama) vision(guildID snowflake.ID, channelID snowflake.ID) bool {
+	return o.modelConfig(guildID, channelID).Vision
 }
 
 // Get system prompts
 func (o *Ollama) prompts(guildID snowflake.ID, channelID snowflake.ID) []OllamaChatMessage {
 	prompts := make([]OllamaChatMessage, 0, 3)
 
-	if cfg := o.cfg.ChannelPrompts[channelID]; cfg.SystemPrompt != "" {
+	if cfg := o.channelPrompt(channelID); cfg.SystemPrompt != "" {
 		prompts = append(prompts, OllamaChatMessage{
 			Role:    OllamaChatMessageRoleSystem,
 			Content: cfg.SystemPrompt,
@@ -114,7 +179,7 @@ func (o *Ollama) prompts(guildID snowflake.ID, channelID snowflake.ID) []OllamaC
 			return prompts
 		}
 	}
-	if cfg := o.cfg.ServerPrompts[guildID]; cfg.SystemPrompt != "" {
+	if cfg := o.serverPrompt(guildID); cfg.SystemPrompt != "" {
 		prompts = slices.Insert(prompts, 0, OllamaChatMessage{
 			Role:    OllamaChatMessageRoleSystem,
 			Content: cfg.SystemPrompt,
@@ -132,41 +197,75 @@ func (o *Ollama) prompts(guildID snowflake.ID, channelID snowflake.ID) []OllamaC
 	return prompts
 }
 
-// do stuff
-func (o *Ollama) Chat(chat OllamaChat) (*OllamaChatResponse, error) {
-	// bad validation probably
-	o.logger.Info("doing request", slog.Any("chat", chat))
+// Generates a completion for a single prompt
+func (o *Ollama) Generate(ctx context.Context, generate OllamaGenerate) (*OllamaGenerateResponse, error) {
+	generate.Stream = false
+	o.logger.Debug("doing generate request", slog.String("model", generate.Model))
 
-	// invoke stuff
-	endpoint, _ := url.JoinPath(o.cfg.Server, o.cfg.ChatPath)
-	jsonData, err := json.Marshal(chat)
+	var generateResp OllamaGenerateResponse
+	err := o.client.Do(ctx, o.cfg.GeneratePath, generate, &generateResp)
 	if err != nil {
 		return nil, err
 	}
+	return &generateResp, nil
+}
+
+// Runs the chat, going through the tool loop when tools are enabled.
+// If onChunk is set the response is streamed.
+func (o *Ollama) complete(ctx context.Context, chat OllamaChat, tc ToolContext, onChunk OllamaChatStreamHandler) (*OllamaChatResponse, error) {
+	if o.cfg.Tools != nil && o.cfg.Tools.Enabled {
+		return o.ChatTools(ctx, chat, tc, onChunk)
+	}
+	guildID, channelID := tc.location()
+	return o.chat(ctx, guildID, channelID, chat, onChunk)
+}
 
-	resp, err := http.Post(endpoint, "application/json", bytes.NewBufferString(string(jsonData)))
+func New(discord *bot.Client, cfg *config.OllamaConfig, storage *config.StorageConfig) (*Ollama, error) {
+	patterns, err := compilePatterns(cfg)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("received non-successful error code '%d' status '%s", resp.StatusCode, resp.Status)
+	backends, err := newBackends(discord.Logger, cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	var chatResp OllamaChatResponse
-	jsonDecoder := json.NewDecoder(resp.Body)
-	err = jsonDecoder.Decode(&chatResp)
-
-	return &chatResp, nil
-}
+	memories, err := store.Open[snowflake.ID, Memory](storage.Path, "ollama_memories")
+	if err != nil {
+		return nil, err
+	}
+	channels, err := store.Open[snowflake.ID, ChannelState](storage.Path, "ollama_channels")
+	if err != nil {
+		return nil, err
+	}
+	storedPrompts, err := store.Open[snowflake.ID, StoredPrompt](storage.Path, "ollama_prompts")
+	if err != nil {
+		return nil, err
+	}
+	promptAudit, err := store.OpenLog[PromptChange](storage.Path, "ollama_prompt_audit")
+	if err != nil {
+		return nil, err
+	}
 
-func New(discord *bot.Client, cfg *config.OllamaConfig) *Ollama {
 	ollama := &Ollama{
-		logger: discord.Logger,
-		cfg:    cfg,
+		logger:        discord.Logger,
+		cfg:           cfg,
+		client:        NewClient(discord.Logger, cfg, cfg.Server),
+		backends:      backends,
+		memories:      memories,
+		channels:      channels,
+		storedPrompts: storedPrompts,
+		promptAudit:   promptAudit,
+		patterns:      patterns,
+		limits:        newLimits(cfg.Limits),
+		unsummarized:  &unsummarized{data: make(map[snowflake.ID]pendingMessages)},
+		reasonings:    &reasonings{data: make(map[snowflake.ID]string)},
+		Tools:         NewToolRegistry(),
 	}
+	ollama.Tools.Register(builtinTools...)
 	discord.AddEventListeners(
 		bot.NewListenerFunc(ollama.onMessageCreate),
 	)
 
-	return ollama
+	return ollama, nil
 }
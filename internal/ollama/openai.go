@@ -0,0 +1,316 @@
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// structs
+// message model for OpenAI compatible chat completion endpoints
+// https://platform.openai.com/docs/api-reference/chat/create
+type openAIChat struct {
+	Model           string                `json:"model"`
+	Messages        []openAIMessage       `json:"messages"`
+	Tools           []OllamaChatTools     `json:"tools,omitempty"` // same shape as ollama
+	ResponseFormat  *openAIResponseFormat `json:"response_format,omitempty"`
+	Temperature     *float64              `json:"temperature,omitempty"`
+	TopP            *float64              `json:"top_p,omitempty"`
+	Seed            *int                  `json:"seed,omitempty"`
+	Stop            []string              `json:"stop,omitempty"`
+	MaxTokens       int                   `json:"max_tokens,omitempty"`
+	ReasoningEffort string                `json:"reasoning_effort,omitempty"`
+	Stream          bool                  `json:"stream"`
+}
+
+type openAIResponseFormat struct {
+	Type       string            `json:"type"` // "json_object" or "json_schema"
+	JSONSchema *openAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+type openAIJSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    any              `json:"content"` // a string, or content parts when there are images
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIToolCall struct {
+	Index    int                    `json:"index,omitempty"`
+	ID       string                 `json:"id,omitempty"`
+	Type     string                 `json:"type,omitempty"`
+	Function openAIToolCallFunction `json:"function"`
+}
+
+type openAIToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"` // JSON encoded as a string
+}
+
+type openAIResponse struct {
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+type openAIChoice struct {
+	Message      openAIResponseMessage `json:"message"`
+	Delta        openAIResponseMessage `json:"delta"` // set instead of message while streaming
+	FinishReason string                `json:"finish_reason"`
+}
+
+type openAIResponseMessage struct {
+	Role             string           `json:"role"`
+	Content          string           `json:"content"`
+	ReasoningContent string           `json:"reasoning_content"` // set by servers like llama.cpp and vLLM for thinking models
+	ToolCalls        []openAIToolCall `json:"tool_calls"`
+}
+
+type openAIProvider struct {
+	client   *Client
+	chatPath string
+	logger   *slog.Logger
+}
+
+// Turns a base64 image into a data URL, the type is sniffed from the image itself
+func dataURL(image string) string {
+	head, _ := base64.StdEncoding.DecodeString(image[:min(len(image), 512)])
+	return fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(head), image)
+}
+
+// Translates a chat in the ollama format
+func newOpenAIChat(chat OllamaChat) openAIChat {
+	req := openAIChat{
+		Model:       chat.Model,
+		Messages:    make([]openAIMessage, 0, len(chat.Messages)),
+		Tools:       chat.Tools,
+		Temperature: chat.Options.Temperature,
+		TopP:        chat.Options.TopP,
+		Seed:        chat.Options.Seed,
+		Stop:        chat.Options.Stop,
+		MaxTokens:   max(chat.Options.NumPredict, 0),
+		Stream:      chat.Stream,
+	}
+	// NOTE:
+	// Only the efforts have an OpenAI counterpart, turning thinking on or off is left to the server
+	if chat.Think != "true" && chat.Think != "false" {
+		req.ReasoningEffort = string(chat.Think)
+	}
+	if bytes.Equal(chat.Format, FormatJSON) {
+		req.ResponseFormat = &openAIResponseFormat{Type: "json_object"}
+	} else if len(chat.Format) > 0 {
+		req.ResponseFormat = &openAIResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &openAIJSONSchema{Name: "response", Schema: chat.Format},
+		}
+	}
+
+	// NOTE:
+	// Ollama has no tool call IDs, the results are matched to the calls by order instead
+	var pending []string
+	for i, msg := range chat.Messages {
+		out := openAIMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		}
+
+		if len(msg.Images) > 0 {
+			parts := []openAIContentPart{{Type: "text", Text: msg.Content}}
+			for _, image := range msg.Images {
+				parts = append(parts, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: dataURL(image)}})
+			}
+			out.Content = parts
+		}
+
+		if len(msg.ToolCalls) > 0 {
+			pending = pending[:0]
+			for j, call := range msg.ToolCalls {
+				id := fmt.Sprintf("call_%d_%d", i, j)
+				pending = append(pending, id)
+				out.ToolCalls = append(out.ToolCalls, openAIToolCall{
+					ID:   id,
+					Type: "function",
+					Function: openAIToolCallFunction{
+						Name:      call.Function.Name,
+						Arguments: string(call.Function.Arguments),
+					},
+				})
+			}
+		}
+
+		if msg.Role == OllamaChatMessageRoleTool && len(pending) > 0 {
+			out.ToolCallID = pending[0]
+			pending = pending[1:]
+		}
+
+		req.Messages = append(req.Messages, out)
+	}
+
+	return req
+}
+
+func toolCalls(calls []openAIToolCall) []OllamaChatToolCalls {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	out := make([]OllamaChatToolCalls, 0, len(calls))
+	for _, call := range calls {
+		var args json.RawMessage
+		if call.Function.Arguments != "" {
+			args = json.RawMessage(call.Function.Arguments)
+		}
+		out = append(out, OllamaChatToolCalls{
+			Function: OllamaChatToolCallFunction{
+				Name:      call.Function.Name,
+				Arguments: args,
+			},
+		})
+	}
+	return out
+}
+
+func (p *openAIProvider) Chat(ctx context.Context, chat OllamaChat) (*OllamaChatResponse, error) {
+	chat.Stream = false
+	p.logger.Debug("doing openai chat request", slog.String("model", chat.Model), slog.Int("messages", len(chat.Messages)))
+
+	var resp openAIResponse
+	err := p.client.Do(ctx, p.chatPath, newOpenAIChat(chat), &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("response has no choices")
+	}
+
+	choice := resp.Choices[0]
+	chatResp := &OllamaChatResponse{
+		Model: resp.Model,
+		Message: OllamaChatMessage{
+			Role:      OllamaChatMessageRoleAssistant,
+			Content:   choice.Message.Content,
+			Thinking:  choice.Message.ReasoningContent,
+			ToolCalls: toolCalls(choice.Message.ToolCalls),
+		},
+		Done:       true,
+		DoneReason: choice.FinishReason,
+	}
+	if resp.Usage != nil {
+		chatResp.PromptEvalCount = resp.Usage.PromptTokens
+		chatResp.EvalCount = resp.Usage.CompletionTokens
+	}
+	return chatResp, nil
+}
+
+func (p *openAIProvider) ChatStream(ctx context.Context, chat OllamaChat, onChunk OllamaChatStreamHandler) (*OllamaChatResponse, error) {
+	chat.Stream = true
+	p.logger.Debug("doing streaming openai chat request", slog.String("model", chat.Model), slog.Int("messages", len(chat.Messages)))
+
+	resp, err := p.client.Post(ctx, p.chatPath, newOpenAIChat(chat))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// NOTE:
+	// The stream is server-sent events, each data line holding a delta until the data is [DONE].
+	// Tool calls arrive in pieces, put together by their index.
+	var content, thinking strings.Builder
+	var calls []openAIToolCall
+	var model, finishReason string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			final := &OllamaChatResponse{
+				Model: model,
+				Message: OllamaChatMessage{
+					Role: OllamaChatMessageRoleAssistant,
+				},
+				Done:       true,
+				DoneReason: finishReason,
+			}
+			err = onChunk(final)
+			if err != nil {
+				return nil, err
+			}
+
+			final.Message.Content = content.String()
+			final.Message.Thinking = thinking.String()
+			final.Message.ToolCalls = toolCalls(calls)
+			return final, nil
+		}
+
+		var event openAIResponse
+		err = json.Unmarshal([]byte(data), &event)
+		if err != nil {
+			return nil, err
+		}
+		if len(event.Choices) == 0 {
+			continue
+		}
+
+		model = event.Model
+		delta := event.Choices[0].Delta
+		finishReason = cmp.Or(event.Choices[0].FinishReason, finishReason)
+		for _, call := range delta.ToolCalls {
+			for len(calls) <= call.Index {
+				calls = append(calls, openAIToolCall{})
+			}
+			calls[call.Index].Function.Name += call.Function.Name
+			calls[call.Index].Function.Arguments += call.Function.Arguments
+		}
+		if delta.Content == "" && delta.ReasoningContent == "" {
+			continue
+		}
+
+		content.WriteString(delta.Content)
+		thinking.WriteString(delta.ReasoningContent)
+		err = onChunk(&OllamaChatResponse{
+			Model: model,
+			Message: OllamaChatMessage{
+				Role:     OllamaChatMessageRoleAssistant,
+				Content:  delta.Content,
+				Thinking: delta.ReasoningContent,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, ErrStreamIncomplete
+}
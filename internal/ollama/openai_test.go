@@ -0,0 +1,211 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+)
+
+// A server streaming the events as SSE, ending with [DONE] if done is set
+func sseServer(t *testing.T, events []string, done bool) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var chat openAIChat
+		err := json.NewDecoder(r.Body).Decode(&chat)
+		if err != nil || !chat.Stream {
+			http.Error(w, `{"error":{"message":"expected a streaming chat"}}`, http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		for _, event := range events {
+			w.Write([]byte("data: " + event + "\n\n"))
+			flusher.Flush()
+		}
+		if done {
+			w.Write([]byte("data: [DONE]\n\n"))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+// Adds the backends to the Ollama, used by the default prompt in the given order
+func withBackends(t *testing.T, o *Ollama, backends map[string]config.OllamaBackendConfig, chain ...string) {
+	t.Helper()
+	o.cfg.Backends = backends
+	o.cfg.DefaultPrompt.Backends = chain
+
+	var err error
+	o.backends, err = newBackends(o.logger, o.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewOpenAIChat(t *testing.T) {
+	chat := OllamaChat{
+		Model: "test",
+		Messages: []OllamaChatMessage{
+			{Role: OllamaChatMessageRoleUser, Content: "what's this?", Images: []string{"iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII="}},
+			{Role: OllamaChatMessageRoleAssistant, ToolCalls: []OllamaChatToolCalls{
+				{Function: OllamaChatToolCallFunction{Name: "time", Arguments: json.RawMessage(`{}`)}},
+				{Function: OllamaChatToolCallFunction{Name: "roll", Arguments: json.RawMessage(`{"sides":6}`)}},
+			}},
+			{Role: OllamaChatMessageRoleTool, Content: "noon"},
+			{Role: OllamaChatMessageRoleTool, Content: "4"},
+		},
+		Format: json.RawMessage(`{"type":"object"}`),
+		Think:  "high",
+		Options: OllamaChatOptions{
+			Temperature: new(0.5),
+			NumPredict:  -1,
+		},
+	}
+
+	req := newOpenAIChat(chat)
+	if req.Model != "test" || *req.Temperature != 0.5 || req.MaxTokens != 0 || req.ReasoningEffort != "high" {
+		t.Fatalf("unexpected options %+v", req)
+	}
+	if req.ResponseFormat == nil || req.ResponseFormat.Type != "json_schema" || string(req.ResponseFormat.JSONSchema.Schema) != `{"type":"object"}` {
+		t.Fatalf("expected the schema as response format, got %+v", req.ResponseFormat)
+	}
+
+	parts, ok := req.Messages[0].Content.([]openAIContentPart)
+	if !ok || len(parts) != 2 || parts[0].Text != "what's this?" || !strings.HasPrefix(parts[1].ImageURL.URL, "data:image/png;base64,") {
+		t.Fatalf("expected the text and the image as parts, got %+v", req.Messages[0].Content)
+	}
+
+	calls := req.Messages[1].ToolCalls
+	if len(calls) != 2 || calls[1].Function.Arguments != `{"sides":6}` {
+		t.Fatalf("unexpected tool calls %+v", calls)
+	}
+	// NOTE:
+	// The results are matched to the calls by order
+	if req.Messages[2].ToolCallID != calls[0].ID || req.Messages[3].ToolCallID != calls[1].ID {
+		t.Fatalf("expected the results to answer %q and %q, got %q and %q", calls[0].ID, calls[1].ID, req.Messages[2].ToolCallID, req.Messages[3].ToolCallID)
+	}
+
+	chat.Format = FormatJSON
+	if req := newOpenAIChat(chat); req.ResponseFormat == nil || req.ResponseFormat.Type != "json_object" {
+		t.Fatalf("expected a JSON object response format, got %+v", req.ResponseFormat)
+	}
+}
+
+func TestOpenAIChatStream(t *testing.T) {
+	events := []string{
+		`{"model":"test","choices":[{"delta":{"role":"assistant","reasoning_content":"hmm"}}]}`,
+		`{"model":"test","choices":[{"delta":{"content":"Hel"}}]}`,
+		`{"model":"test","choices":[{"delta":{"content":"lo"}}]}`,
+		`{"model":"test","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"name":"time","arguments":"{\"zone\":"}}]}}]}`,
+		`{"model":"test","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"UTC\"}"}}]},"finish_reason":"tool_calls"}]}`,
+	}
+
+	t.Run("done", func(t *testing.T) {
+		srv, _ := sseServer(t, events, true)
+		o := newTestOllama(t, srv.URL)
+		withBackends(t, o, map[string]config.OllamaBackendConfig{"openai": {Type: BackendTypeOpenAI, Server: srv.URL}}, "openai")
+
+		chunks := 0
+		res, err := o.chat(context.Background(), 0, testChannelID, testChat(), func(chunk *OllamaChatResponse) error {
+			chunks++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunks != 4 {
+			t.Fatalf("expected 4 chunks, got %d", chunks)
+		}
+		if res.Message.Content != "Hello" || res.Message.Thinking != "hmm" || !res.Done || res.DoneReason != "tool_calls" {
+			t.Fatalf("unexpected response %+v", res)
+		}
+		if len(res.Message.ToolCalls) != 1 || string(res.Message.ToolCalls[0].Function.Arguments) != `{"zone":"UTC"}` {
+			t.Fatalf("expected the pieces of the tool call put together, got %+v", res.Message.ToolCalls)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		srv, _ := sseServer(t, events[:3], false)
+		o := newTestOllama(t, srv.URL)
+		withBackends(t, o, map[string]config.OllamaBackendConfig{"openai": {Type: BackendTypeOpenAI, Server: srv.URL}}, "openai")
+
+		_, err := o.chat(context.Background(), 0, testChannelID, testChat(), func(chunk *OllamaChatResponse) error {
+			return nil
+		})
+		if !errors.Is(err, ErrStreamIncomplete) {
+			t.Fatalf("expected %v, got %v", ErrStreamIncomplete, err)
+		}
+	})
+}
+
+func TestChatFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		cancel   bool
+		requests int32 // requests expected on the secondary backend
+	}{
+		{
+			name:     "model missing",
+			status:   http.StatusNotFound,
+			body:     `{"error":"model not found"}`,
+			requests: 1,
+		},
+		{
+			name:     "server error",
+			status:   http.StatusInternalServerError,
+			body:     `{"error":"model crashed"}`,
+			requests: 1,
+		},
+		{
+			name:   "cancelled",
+			status: http.StatusInternalServerError,
+			cancel: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, _ := failingServer(t, 1, tt.status, tt.body)
+			secondary, requests := sseServer(t, []string{`{"model":"test","choices":[{"delta":{"content":"ok"}}]}`}, true)
+			o := newTestOllama(t, primary.URL)
+			withBackends(t, o, map[string]config.OllamaBackendConfig{"openai": {Type: BackendTypeOpenAI, Server: secondary.URL}}, DefaultBackend, "openai")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+
+			res, err := o.chat(ctx, 0, testChannelID, testChat(), func(chunk *OllamaChatResponse) error {
+				return nil
+			})
+			if got := requests.Load(); got != tt.requests {
+				t.Fatalf("expected %d requests on the secondary, got %d", tt.requests, got)
+			}
+			if tt.cancel {
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("expected the cancellation, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Message.Content != "ok" {
+				t.Fatalf("expected the answer of the secondary, got %q", res.Message.Content)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package ollama
+
+import (
+	"encoding/json"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// OllamaThink turns thinking on or off with "true" or "false", or sets its effort with "high", "medium" or "low"
+type OllamaThink string
+
+// NOTE:
+// Ollama takes a boolean or an effort string for think
+func (t OllamaThink) MarshalJSON() ([]byte, error) {
+	if t == "true" || t == "false" {
+		return []byte(t), nil
+	}
+	return json.Marshal(string(t))
+}
+
+// Get the generation options, the most specific config wins field by field
+func (o *Ollama) options(guildID snowflake.ID, channelID snowflake.ID) config.OllamaOptionsConfig {
+	opts := config.OllamaOptionsConfig{}
+
+	cfgs := []*config.OllamaOptionsConfig{
+		o.cfg.DefaultPrompt.Options,
+		o.serverPrompt(guildID).Options,
+		o.channelPrompt(channelID).Options,
+	}
+	for _, cfg := range cfgs {
+		if cfg == nil {
+			continue
+		}
+		if cfg.Temperature != nil {
+			opts.Temperature = cfg.Temperature
+		}
+		if cfg.TopK != nil {
+			opts.TopK = cfg.TopK
+		}
+		if cfg.TopP != nil {
+			opts.TopP = cfg.TopP
+		}
+		if cfg.MinP != nil {
+			opts.MinP = cfg.MinP
+		}
+		if cfg.NumPredict != nil {
+			opts.NumPredict = cfg.NumPredict
+		}
+		if cfg.Seed != nil {
+			opts.Seed = cfg.Seed
+		}
+		if cfg.Stop != nil {
+			opts.Stop = cfg.Stop
+		}
+		if cfg.Think != "" {
+			opts.Think = cfg.Think
+		}
+		if cfg.KeepAlive != nil {
+			opts.KeepAlive = cfg.KeepAlive
+		}
+		if cfg.Format != "" {
+			opts.Format = cfg.Format
+		}
+	}
+	return opts
+}
+
+// Sets the generation options of the channel on the chat
+func (o *Ollama) applyOptions(chat *OllamaChat, guildID snowflake.ID, channelID snowflake.ID) {
+	opts := o.options(guildID, channelID)
+
+	chat.Options.Temperature = new(float64(DefaultTemperature))
+	if opts.Temperature != nil {
+		chat.Options.Temperature = opts.Temperature
+	}
+	if opts.TopK != nil {
+		chat.Options.TopK = *opts.TopK
+	}
+	chat.Options.TopP = opts.TopP
+	chat.Options.MinP = opts.MinP
+	if opts.NumPredict != nil {
+		chat.Options.NumPredict = *opts.NumPredict
+	}
+	chat.Options.Seed = opts.Seed
+	chat.Options.Stop = opts.Stop
+	chat.Think = OllamaThink(opts.Think)
+	if opts.KeepAlive != nil {
+		chat.KeepAlive = opts.KeepAlive.String()
+	}
+	if opts.Format == "json" {
+		chat.Format = FormatJSON
+	}
+}
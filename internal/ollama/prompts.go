@@ -0,0 +1,191 @@
+package ollama
+
+import (
+	"fmt"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	DefaultPromptMaxLength      = 2000
+	DefaultPromptMaxGuildLength = 8000
+	PromptHistoryEntries        = 15 // changes shown by /chat prompt history
+)
+
+// A StoredPrompt is a system prompt set with commands.
+// It replaces the system prompt of the config for its server or channel, keeping the rest of the config.
+type StoredPrompt struct {
+	GuildID      snowflake.ID `json:"guild_id"`
+	ChannelID    snowflake.ID `json:"channel_id,omitempty"` // 0 for the server prompt
+	SystemPrompt string       `json:"system_prompt"`
+	Exclusive    bool         `json:"exclusive,omitempty"`
+	UpdatedBy    snowflake.ID `json:"updated_by"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+type PromptAction = string
+
+const (
+	PromptActionSet   PromptAction = "set"
+	PromptActionClear PromptAction = "clear"
+)
+
+// A PromptChange is an entry in the audit trail of the stored prompts
+type PromptChange struct {
+	Time         time.Time    `json:"time"`
+	Action       PromptAction `json:"action"`
+	GuildID      snowflake.ID `json:"guild_id"`
+	ChannelID    snowflake.ID `json:"channel_id,omitempty"`
+	UserID       snowflake.ID `json:"user_id"`
+	SystemPrompt string       `json:"system_prompt,omitempty"`
+	Exclusive    bool         `json:"exclusive,omitempty"`
+}
+
+// NOTE:
+// Guild and channel IDs are both snowflakes and never collide, so they share one store
+func promptKey(guildID snowflake.ID, channelID snowflake.ID) snowflake.ID {
+	if channelID != 0 {
+		return channelID
+	}
+	return guildID
+}
+
+// Puts the stored prompt on top of the config
+func layerPrompt(cfg config.OllamaSystemPromptConfig, stored StoredPrompt, ok bool) config.OllamaSystemPromptConfig {
+	if ok {
+		cfg.SystemPrompt = stored.SystemPrompt
+		cfg.Exclusive = stored.Exclusive
+	}
+	return cfg
+}
+
+// Get the server prompt, a stored prompt replaces the configured one
+func (o *Ollama) serverPrompt(guildID snowflake.ID) config.OllamaSystemPromptConfig {
+	stored, ok := o.storedPrompts.Get(guildID)
+	return layerPrompt(o.cfg.ServerPrompts[guildID], stored, ok)
+}
+
+func (o *Ollama) promptLimits() (int, int) {
+	maxLength, maxGuildLength := DefaultPromptMaxLength, DefaultPromptMaxGuildLength
+	if cfg := o.cfg.Prompts; cfg != nil {
+		if cfg.MaxLength > 0 {
+			maxLength = cfg.MaxLength
+		}
+		if cfg.MaxGuildLength > 0 {
+			maxGuildLength = cfg.MaxGuildLength
+		}
+	}
+	return maxLength, maxGuildLength
+}
+
+// Prompt returns the stored prompt of the server, or of the channel if channelID is set
+func (o *Ollama) Prompt(guildID snowflake.ID, channelID snowflake.ID) (StoredPrompt, bool) {
+	return o.storedPrompts.Get(promptKey(guildID, channelID))
+}
+
+// Prompts returns every stored prompt of the server, including the ones of its channels
+func (o *Ollama) Prompts(guildID snowflake.ID) []StoredPrompt {
+	var prompts []StoredPrompt
+	for _, prompt := range o.storedPrompts.All() {
+		if prompt.GuildID == guildID {
+			prompts = append(prompts, prompt)
+		}
+	}
+	return prompts
+}
+
+// SetPrompt stores the prompt of the server, or of the channel if channelID is set
+func (o *Ollama) SetPrompt(guildID snowflake.ID, channelID snowflake.ID, userID snowflake.ID, prompt string, exclusive bool) error {
+	maxLength, maxGuildLength := o.promptLimits()
+
+	length := utf8.RuneCountInString(prompt)
+	if length > maxLength {
+		return fmt.Errorf("prompts can be at most %d characters, this one is %d", maxLength, length)
+	}
+
+	// NOTE:
+	// The other prompts of the server are counted and the new one stored in one go,
+	// otherwise two prompts set at once could both fit the limit
+	o.promptsM.Lock()
+	defer o.promptsM.Unlock()
+
+	key := promptKey(guildID, channelID)
+	total := length
+	for _, stored := range o.Prompts(guildID) {
+		if promptKey(stored.GuildID, stored.ChannelID) != key {
+			total += utf8.RuneCountInString(stored.SystemPrompt)
+		}
+	}
+	if total > maxGuildLength {
+		return fmt.Errorf("the prompts of a server can be at most %d characters together, this would make them %d", maxGuildLength, total)
+	}
+
+	// NOTE:
+	// The change is logged before it is made, so no prompt is ever stored without a trace
+	now := time.Now()
+	err := o.promptAudit.Append(PromptChange{
+		Time:         now,
+		Action:       PromptActionSet,
+		GuildID:      guildID,
+		ChannelID:    channelID,
+		UserID:       userID,
+		SystemPrompt: prompt,
+		Exclusive:    exclusive,
+	})
+	if err != nil {
+		return err
+	}
+
+	return o.storedPrompts.Set(key, StoredPrompt{
+		GuildID:      guildID,
+		ChannelID:    channelID,
+		SystemPrompt: prompt,
+		Exclusive:    exclusive,
+		UpdatedBy:    userID,
+		UpdatedAt:    now,
+	})
+}
+
+// ClearPrompt removes the stored prompt of the server, or of the channel if channelID is set
+func (o *Ollama) ClearPrompt(guildID snowflake.ID, channelID snowflake.ID, userID snowflake.ID) error {
+	o.promptsM.Lock()
+	defer o.promptsM.Unlock()
+
+	err := o.promptAudit.Append(PromptChange{
+		Time:      time.Now(),
+		Action:    PromptActionClear,
+		GuildID:   guildID,
+		ChannelID: channelID,
+		UserID:    userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return o.storedPrompts.Delete(promptKey(guildID, channelID))
+}
+
+// PromptHistory returns the latest changes to the stored prompts of the server, newest first
+func (o *Ollama) PromptHistory(guildID snowflake.ID, limit int) ([]PromptChange, error) {
+	var changes []PromptChange
+	err := o.promptAudit.Scan(func(change PromptChange) bool {
+		if change.GuildID != guildID {
+			return true
+		}
+		changes = append(changes, change)
+		if len(changes) > limit {
+			changes = changes[1:]
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(changes)
+	return changes, nil
+}
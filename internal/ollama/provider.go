@@ -0,0 +1,226 @@
+package ollama
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+type BackendType = string
+
+const (
+	BackendTypeOllama BackendType = "ollama" // ollama's own chat endpoint
+	BackendTypeOpenAI BackendType = "openai" // any OpenAI compatible chat completions endpoint
+)
+
+// The backend made from the top level server config
+const DefaultBackend = "default"
+
+// A Provider runs chats against an LLM backend.
+// Chats use the ollama wire format, providers for other backends translate them.
+type Provider interface {
+	Chat(ctx context.Context, chat OllamaChat) (*OllamaChatResponse, error)
+	// Streams a chat, calling onChunk for every partial response.
+	// The returned response holds the full message and the statistics of the final chunk.
+	ChatStream(ctx context.Context, chat OllamaChat, onChunk OllamaChatStreamHandler) (*OllamaChatResponse, error)
+}
+
+type OllamaChatStreamHandler func(chunk *OllamaChatResponse) error
+
+type backend struct {
+	name     string
+	model    string // model name on this backend, empty to use the prompt model
+	provider Provider
+}
+
+// Creates the configured backends, next to the default one
+func newBackends(logger *slog.Logger, cfg *config.OllamaConfig) (map[string]backend, error) {
+	backends := map[string]backend{
+		DefaultBackend: {
+			name:     DefaultBackend,
+			provider: &ollamaProvider{client: NewClient(logger, cfg, cfg.Server), chatPath: cfg.ChatPath, logger: logger},
+		},
+	}
+
+	for name, backendCfg := range cfg.Backends {
+		if name == DefaultBackend {
+			return nil, fmt.Errorf("backend name %q is reserved", name)
+		}
+
+		client := NewClient(logger, cfg, backendCfg.Server)
+		if backendCfg.APIKey != "" {
+			client.Header.Set("Authorization", "Bearer "+backendCfg.APIKey)
+		}
+
+		var provider Provider
+		switch backendCfg.Type {
+		case BackendTypeOllama, "":
+			provider = &ollamaProvider{client: client, chatPath: cmp.Or(backendCfg.ChatPath, "/api/chat"), logger: logger}
+		case BackendTypeOpenAI:
+			provider = &openAIProvider{client: client, chatPath: cmp.Or(backendCfg.ChatPath, "/v1/chat/completions"), logger: logger}
+		default:
+			return nil, fmt.Errorf("backend %q has unknown type %q", name, backendCfg.Type)
+		}
+
+		backends[name] = backend{
+			name:     name,
+			model:    backendCfg.Model,
+			provider: provider,
+		}
+	}
+
+	// NOTE:
+	// Catch typos in the prompt configs at startup instead of on the first message
+	prompts := append([]config.OllamaSystemPromptConfig{cfg.DefaultPrompt}, cfg.Personas...)
+	for _, prompt := range cfg.ServerPrompts {
+		prompts = append(prompts, prompt)
+	}
+	for _, prompt := range cfg.ChannelPrompts {
+		prompts = append(prompts, prompt)
+	}
+	for _, prompt := range prompts {
+		for _, name := range prompt.Backends {
+			if _, ok := backends[name]; !ok {
+				return nil, fmt.Errorf("prompt %q uses unknown backend %q", prompt.Name, name)
+			}
+		}
+	}
+
+	return backends, nil
+}
+
+// Get the backends to try in order, decided together with the model
+func (o *Ollama) backendChain(guildID snowflake.ID, channelID snowflake.ID) []backend {
+	names := o.modelConfig(guildID, channelID).Backends
+	if len(names) == 0 {
+		return []backend{o.backends[DefaultBackend]}
+	}
+
+	chain := make([]backend, 0, len(names))
+	for _, name := range names {
+		chain = append(chain, o.backends[name])
+	}
+	return chain
+}
+
+// Runs the chat on the backends of the channel, falling back to the next one when a backend fails.
+// If onChunk is set the response is streamed.
+func (o *Ollama) chat(ctx context.Context, guildID snowflake.ID, channelID snowflake.ID, chat OllamaChat, onChunk OllamaChatStreamHandler) (*OllamaChatResponse, error) {
+	chain := o.backendChain(guildID, channelID)
+	model := chat.Model
+	// NOTE:
+	// The backend model stands in for the prompt model, a model picked for the request like with /chat ask wins
+	picked := model != o.model(guildID, channelID)
+
+	var err error
+	for i, b := range chain {
+		chat.Model = model
+		if !picked {
+			chat.Model = cmp.Or(b.model, model)
+		}
+
+		var res *OllamaChatResponse
+		streamed := false
+		if onChunk != nil {
+			res, err = b.provider.ChatStream(ctx, chat, func(chunk *OllamaChatResponse) error {
+				streamed = true
+				return onChunk(chunk)
+			})
+		} else {
+			res, err = b.provider.Chat(ctx, chat)
+		}
+		if err == nil {
+			return res, nil
+		}
+
+		// NOTE:
+		// Any failure of a backend moves on to the next one, like a missing model or a timeout,
+		// unless the caller gave up. A stream that already delivered chunks can't be taken over.
+		if streamed || ctx.Err() != nil || i == len(chain)-1 {
+			break
+		}
+		o.logger.Warn("Backend failed, falling back", slog.String("backend", b.name), slog.String("fallback", chain[i+1].name), slog.Any("error", err))
+	}
+	return nil, err
+}
+
+// -- OLLAMA --
+
+type ollamaProvider struct {
+	client   *Client
+	chatPath string
+	logger   *slog.Logger
+}
+
+func (p *ollamaProvider) Chat(ctx context.Context, chat OllamaChat) (*OllamaChatResponse, error) {
+	chat.Stream = false
+	p.logger.Debug("doing chat request", slog.String("model", chat.Model), slog.Int("messages", len(chat.Messages)))
+
+	var chatResp OllamaChatResponse
+	err := p.client.Do(ctx, p.chatPath, chat, &chatResp)
+	if err != nil {
+		return nil, err
+	}
+	return &chatResp, nil
+}
+
+func (p *ollamaProvider) ChatStream(ctx context.Context, chat OllamaChat, onChunk OllamaChatStreamHandler) (*OllamaChatResponse, error) {
+	chat.Stream = true
+	p.logger.Debug("doing streaming chat request", slog.String("model", chat.Model), slog.Int("messages", len(chat.Messages)))
+
+	// NOTE:
+	// Only the request itself is retried, a stream failing halfway would repeat the chunks already handled
+	resp, err := p.client.Post(ctx, p.chatPath, chat)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// NOTE:
+	// Ollama streams one JSON object per line, the last one having done set to true
+	var content, thinking strings.Builder
+	var toolCalls []OllamaChatToolCalls
+	var chunk OllamaChatResponse
+	jsonDecoder := json.NewDecoder(resp.Body)
+	for {
+		chunk = OllamaChatResponse{}
+		err = jsonDecoder.Decode(&chunk)
+		if err != nil {
+			// NOTE:
+			// The connection can also drop in the middle of a chunk
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, ErrStreamIncomplete
+			}
+			return nil, err
+		}
+		// NOTE:
+		// Failures after the stream started are sent as a chunk with only the error
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("%w: %s", ErrStreamFailed, chunk.Error)
+		}
+
+		content.WriteString(chunk.Message.Content)
+		thinking.WriteString(chunk.Message.Thinking)
+		toolCalls = append(toolCalls, chunk.Message.ToolCalls...)
+		err = onChunk(&chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		if chunk.Done {
+			break
+		}
+	}
+
+	chunk.Message.Content = content.String()
+	chunk.Message.Thinking = thinking.String()
+	chunk.Message.ToolCalls = toolCalls
+	return &chunk, nil
+}
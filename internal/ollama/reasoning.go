@@ -0,0 +1,114 @@
+package ollama
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+type ReasoningDisplay = string
+
+const (
+	ReasoningDisplayHide    ReasoningDisplay = "hide"    // the reasoning is dropped
+	ReasoningDisplaySpoiler ReasoningDisplay = "spoiler" // the reasoning is sent as a spoiler before the answer
+	ReasoningDisplayButton  ReasoningDisplay = "button"  // the answer gets a button showing the reasoning to whoever clicks it
+)
+
+const (
+	ReasoningHeader      = "-# 💭 reasoning\n"
+	ReasoningButtonLabel = "Show reasoning"
+	// NOTE:
+	// The button is handled by the chat command, the ID of the message being answered is appended
+	ReasoningButtonID = "/chat/reasoning/"
+	// How long the reasoning behind a button is kept, it is only held in memory
+	ReasoningRetention = 24 * time.Hour
+	// Room left for the header and the escaped spoiler, escaping can double the length
+	reasoningSpoilerLength = (MessageMaxLength - 100) / 2
+)
+
+// Matches a reasoning spoiler, the pipes and backslashes inside are escaped
+var RegexpReasoning = regexp.MustCompile(`-# 💭 reasoning\n\|\|(?:\\.|[^\\|])*\|\|\n*`)
+
+// Reasoning kept for the show reasoning buttons, by the ID of the message that was answered
+type reasonings struct {
+	m    sync.Mutex
+	data map[snowflake.ID]string
+}
+
+func (r *reasonings) set(id snowflake.ID, reasoning string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for key := range r.data {
+		if time.Since(key.Time()) > ReasoningRetention {
+			delete(r.data, key)
+		}
+	}
+	r.data[id] = reasoning
+}
+
+func (r *reasonings) get(id snowflake.ID) (string, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	reasoning, ok := r.data[id]
+	return reasoning, ok
+}
+
+// Reasoning returns the reasoning behind the answer to the message, for the show reasoning button
+func (o *Ollama) Reasoning(id snowflake.ID) (string, bool) {
+	return o.reasonings.get(id)
+}
+
+// Wraps the reasoning in a spoiler that fits in a single message
+func reasoningSpoiler(reasoning string) string {
+	if truncated := truncateRunes(reasoning, reasoningSpoilerLength); truncated != reasoning {
+		reasoning = truncated + "…"
+	}
+	reasoning = strings.ReplaceAll(reasoning, `\`, `\\`)
+	reasoning = strings.ReplaceAll(reasoning, "|", `\|`)
+	return ReasoningHeader + "||" + reasoning + "||"
+}
+
+// Adds the reasoning of the result to the messages answering the message with the given ID, as configured
+func (o *Ollama) withReasoning(res *OllamaChatResponse, id snowflake.ID, msgs []discord.MessageCreate) []discord.MessageCreate {
+	if res == nil || len(msgs) == 0 {
+		return msgs
+	}
+	reasoning := strings.TrimSpace(res.Message.Thinking)
+	if reasoning == "" {
+		return msgs
+	}
+
+	switch o.cfg.Reasoning {
+	case ReasoningDisplaySpoiler:
+		spoiler := discord.NewMessageCreate().WithContent(reasoningSpoiler(reasoning)).WithAllowedMentions(o.allowedMentions())
+		return append([]discord.MessageCreate{spoiler}, msgs...)
+	case ReasoningDisplayButton:
+		o.reasonings.set(id, reasoning)
+		msgs[0] = msgs[0].AddActionRow(
+			discord.NewSecondaryButton(ReasoningButtonLabel, ReasoningButtonID+id.String()).WithEmoji(discord.ComponentEmoji{Name: "💭"}),
+		)
+	}
+	return msgs
+}
+
+// NOTE:
+// Only the answers are fed back to the model, the reasoning shown with them is left out
+func stripReasoning(msgs []discord.Message) []discord.Message {
+	stripped := msgs[:0]
+	for _, msg := range msgs {
+		if msg.Author.Bot && RegexpReasoning.MatchString(msg.Content) {
+			msg.Content = strings.TrimSpace(RegexpReasoning.ReplaceAllString(msg.Content, ""))
+			if msg.Content == "" && len(msg.Attachments) == 0 {
+				continue
+			}
+		}
+		stripped = append(stripped, msg)
+	}
+	return stripped
+}
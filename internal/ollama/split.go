@@ -0,0 +1,146 @@
+package ollama
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	AttachmentName = "answer.md"
+	AttachmentNote = "that got a bit long, so here it is as a file 📄"
+	// NOTE:
+	// Room left in every chunk to close a code block spanning chunks
+	fenceClose = "\n```"
+)
+
+// Returns the longest prefix of s that is at most n runes
+func truncateRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// Finds where to cut the text, preferring paragraphs, then lines, sentences and words.
+// Cuts in the first half are skipped, so the chunks don't get needlessly short.
+func cutPoint(window string) int {
+	half := len(window) / 2
+
+	if i := strings.LastIndex(window, "\n\n"); i > half {
+		return i
+	}
+	if i := strings.LastIndex(window, "\n"); i > half {
+		return i
+	}
+
+	sentence := -1
+	for _, end := range []string{". ", "! ", "? "} {
+		sentence = max(sentence, strings.LastIndex(window, end))
+	}
+	if sentence > half {
+		return sentence + 1
+	}
+
+	if i := strings.LastIndex(window, " "); i > half {
+		return i
+	}
+	return len(window)
+}
+
+// Returns the opening line of the code block left open at the end of the text, if any
+func openFence(text string) string {
+	fence := ""
+	for line := range strings.Lines(text) {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "```") {
+			continue
+		}
+		if fence == "" {
+			fence = line
+		} else {
+			fence = ""
+		}
+	}
+	return fence
+}
+
+// SplitMessage splits markdown into chunks of at most limit runes.
+// A code block cut in two is closed at the end of the chunk and opened again in the next.
+func SplitMessage(content string, limit int) []string {
+	var chunks []string
+	prefix := 0 // length of the code block opened again at the start of the content
+	for utf8.RuneCountInString(content) > limit {
+		window := truncateRunes(content, max(limit-len(fenceClose), 1))
+		cut := cutPoint(window)
+		// NOTE:
+		// A chunk holding nothing but the opened code block would be split the same way forever,
+		// so it is cut as late as possible instead
+		if cut <= prefix {
+			cut = len(window)
+		}
+		chunk := strings.TrimRight(content[:cut], " \n")
+		rest := content[cut:]
+
+		// NOTE:
+		// The code block is only opened again when the rest gets shorter for it,
+		// a fence line that long is left out rather than repeated
+		prefix = 0
+		if fence := openFence(chunk); fence != "" {
+			chunk += fenceClose
+			if len(fence)+1 < cut {
+				rest = fence + "\n" + strings.TrimPrefix(rest, "\n")
+				prefix = len(fence) + 1
+			}
+		} else {
+			rest = strings.TrimLeft(rest, " \n")
+		}
+
+		if strings.TrimSpace(chunk) != "" {
+			chunks = append(chunks, chunk)
+		}
+		content = rest
+	}
+
+	if strings.TrimSpace(content) != "" {
+		chunks = append(chunks, content)
+	}
+	return chunks
+}
+
+// Messages turns an answer into the messages to send, either split in chunks or attached as a file.
+// The messages only allow the mentions of the mention policy.
+func (o *Ollama) Messages(answer string) []discord.MessageCreate {
+	if o.cfg.AttachLength > 0 && utf8.RuneCountInString(answer) > o.cfg.AttachLength {
+		return []discord.MessageCreate{
+			discord.NewMessageCreate().
+				WithContent(AttachmentNote).
+				AddFiles(discord.NewFile(AttachmentName, "", strings.NewReader(answer))).
+				WithAllowedMentions(o.allowedMentions()),
+		}
+	}
+
+	chunks := SplitMessage(answer, MessageMaxLength)
+	msgs := make([]discord.MessageCreate, 0, len(chunks))
+	for _, chunk := range chunks {
+		msgs = append(msgs, discord.NewMessageCreate().WithContent(chunk).WithAllowedMentions(o.allowedMentions()))
+	}
+	return msgs
+}
+
+// Sends the messages as a reply chain, starting with a reply to the given message
+func (o *Ollama) send(client messageClient, channelID snowflake.ID, replyTo snowflake.ID, msgs []discord.MessageCreate) error {
+	for _, msg := range msgs {
+		sent, err := client.CreateMessage(channelID, msg.WithMessageReferenceByID(replyTo))
+		if err != nil {
+			return err
+		}
+		replyTo = sent.ID
+	}
+	return nil
+}
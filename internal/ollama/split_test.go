@@ -0,0 +1,102 @@
+package ollama
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSplitMessage(t *testing.T) {
+	fence := "```" + strings.Repeat("x", 30)
+	tests := []struct {
+		name    string
+		content string
+		limit   int
+		chunks  []string // expected chunks, only checked if set
+	}{
+		{
+			name:    "fits",
+			content: "hello there",
+			limit:   20,
+			chunks:  []string{"hello there"},
+		},
+		{
+			name:    "paragraphs",
+			content: "first paragraph\n\nsecond paragraph",
+			limit:   20,
+			chunks:  []string{"first paragraph", "second paragraph"},
+		},
+		{
+			name:    "words",
+			content: "one two three four five six",
+			limit:   16,
+			chunks:  []string{"one two", "three four", "five six"},
+		},
+		{
+			name:    "no spaces",
+			content: strings.Repeat("a", 25),
+			limit:   10,
+			chunks:  []string{"aaaaaa", "aaaaaa", "aaaaaa", "aaaaaaa"},
+		},
+		{
+			name:    "code block",
+			content: "look:\n```go\nfmt.Println(1)\nfmt.Println(2)\nfmt.Println(3)\n```",
+			limit:   40,
+			chunks:  []string{"look:\n```go\nfmt.Println(1)\n```", "```go\nfmt.Println(2)\nfmt.Println(3)\n```"},
+		},
+		{
+			// NOTE:
+			// Used to loop forever, the chunk after the fence line only held the fence line again
+			name:    "fence line over half the limit",
+			content: fence + "\n" + strings.Repeat("code line\n", 10) + "```",
+			limit:   50,
+		},
+		{
+			name:    "fence line over the limit",
+			content: "```" + strings.Repeat("x", 80) + "\ncode\n```",
+			limit:   50,
+		},
+		{
+			name:    "fence line without spaces after it",
+			content: fence + "\n" + strings.Repeat("y", 100) + "\n```",
+			limit:   50,
+		},
+		{
+			name:    "multibyte",
+			content: strings.Repeat("æøå ", 30),
+			limit:   16,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan []string)
+			go func() {
+				done <- SplitMessage(tt.content, tt.limit)
+			}()
+
+			var chunks []string
+			select {
+			case chunks = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("split did not finish")
+			}
+
+			if tt.chunks != nil && !slices.Equal(chunks, tt.chunks) {
+				t.Fatalf("expected %q, got %q", tt.chunks, chunks)
+			}
+			if len(chunks) == 0 {
+				t.Fatal("expected at least one chunk")
+			}
+			for _, chunk := range chunks {
+				if n := utf8.RuneCountInString(chunk); n > tt.limit {
+					t.Fatalf("chunk of %d runes over the limit of %d: %q", n, tt.limit, chunk)
+				}
+				if strings.TrimSpace(chunk) == "" {
+					t.Fatal("chunks should not be empty")
+				}
+			}
+		})
+	}
+}
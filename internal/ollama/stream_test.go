@@ -0,0 +1,306 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+	"github.com/Akvanvig/roboto-go/internal/store"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const testChannelID = snowflake.ID(100)
+
+// Creates an Ollama talking to the server, keeping its stores in a temporary directory
+func newTestOllama(t *testing.T, server string) *Ollama {
+	t.Helper()
+	logger := slog.New(slog.DiscardHandler)
+	cfg := &config.OllamaConfig{
+		Server:        server,
+		ChatPath:      "/api/chat",
+		Retries:       -1,
+		DefaultPrompt: config.OllamaSystemPromptConfig{Model: "test"},
+	}
+	backends, err := newBackends(logger, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	memories, err := store.Open[snowflake.ID, Memory](dir, "ollama_memories")
+	if err != nil {
+		t.Fatal(err)
+	}
+	channels, err := store.Open[snowflake.ID, ChannelState](dir, "ollama_channels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedPrompts, err := store.Open[snowflake.ID, StoredPrompt](dir, "ollama_prompts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	promptAudit, err := store.OpenLog[PromptChange](dir, "ollama_prompt_audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Ollama{
+		logger:        logger,
+		cfg:           cfg,
+		client:        NewClient(logger, cfg, server),
+		backends:      backends,
+		memories:      memories,
+		channels:      channels,
+		storedPrompts: storedPrompts,
+		promptAudit:   promptAudit,
+		limits:        newLimits(nil),
+		unsummarized:  &unsummarized{data: make(map[snowflake.ID]pendingMessages)},
+		reasonings:    &reasonings{data: make(map[snowflake.ID]string)},
+		Tools:         NewToolRegistry(),
+	}
+}
+
+// A server streaming the chunks as NDJSON, waiting for delay(i) before chunk i.
+// The body is cut off after the given number of bytes of the last chunk if truncate is set.
+func ndjsonServer(t *testing.T, chunks []OllamaChatResponse, delay func(i int) time.Duration, truncate int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var chat OllamaChat
+		err := json.NewDecoder(r.Body).Decode(&chat)
+		if err != nil || !chat.Stream {
+			http.Error(w, `{"error":"expected a streaming chat"}`, http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher := w.(http.Flusher)
+		for i, chunk := range chunks {
+			if delay != nil {
+				time.Sleep(delay(i))
+			}
+			data, _ := json.Marshal(chunk)
+			if truncate > 0 && i == len(chunks)-1 {
+				w.Write(data[:truncate])
+				flusher.Flush()
+				return
+			}
+			w.Write(append(data, '\n'))
+			flusher.Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func contentChunks(parts ...string) []OllamaChatResponse {
+	chunks := make([]OllamaChatResponse, 0, len(parts)+1)
+	for _, part := range parts {
+		chunks = append(chunks, OllamaChatResponse{Message: OllamaChatMessage{Role: OllamaChatMessageRoleAssistant, Content: part}})
+	}
+	return append(chunks, OllamaChatResponse{Done: true, DoneReason: "stop", EvalCount: len(parts)})
+}
+
+func testChat() OllamaChat {
+	return OllamaChat{
+		Model:    "test",
+		Messages: []OllamaChatMessage{{Role: OllamaChatMessageRoleUser, Content: "hi"}},
+	}
+}
+
+func TestChatStreamAssemblesChunks(t *testing.T) {
+	srv := ndjsonServer(t, []OllamaChatResponse{
+		{Message: OllamaChatMessage{Role: OllamaChatMessageRoleAssistant, Thinking: "hmm, "}},
+		{Message: OllamaChatMessage{Role: OllamaChatMessageRoleAssistant, Thinking: "a greeting"}},
+		{Message: OllamaChatMessage{Role: OllamaChatMessageRoleAssistant, Content: "Hel"}},
+		{Message: OllamaChatMessage{Role: OllamaChatMessageRoleAssistant, Content: "lo"}},
+		{Message: OllamaChatMessage{Role: OllamaChatMessageRoleAssistant, ToolCalls: []OllamaChatToolCalls{{Function: OllamaChatToolCallFunction{Name: "time"}}}}},
+		{Done: true, DoneReason: "stop", EvalCount: 5},
+	}, nil, 0)
+	o := newTestOllama(t, srv.URL)
+
+	chunks := 0
+	res, err := o.chat(context.Background(), 0, testChannelID, testChat(), func(chunk *OllamaChatResponse) error {
+		chunks++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunks != 6 {
+		t.Fatalf("expected 6 chunks, got %d", chunks)
+	}
+	if res.Message.Content != "Hello" || res.Message.Thinking != "hmm, a greeting" {
+		t.Fatalf("unexpected message %+v", res.Message)
+	}
+	if len(res.Message.ToolCalls) != 1 || res.Message.ToolCalls[0].Function.Name != "time" {
+		t.Fatalf("expected the tool call, got %+v", res.Message.ToolCalls)
+	}
+	if !res.Done || res.EvalCount != 5 {
+		t.Fatalf("expected the statistics of the final chunk, got %+v", res)
+	}
+}
+
+func TestChatStreamIncomplete(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunks   []OllamaChatResponse
+		truncate int
+		err      error
+		message  string // the reason reported by the server, if any
+	}{
+		{
+			name:   "no done chunk",
+			chunks: contentChunks("Hel", "lo")[:2],
+			err:    ErrStreamIncomplete,
+		},
+		{
+			name:     "cut in a chunk",
+			chunks:   contentChunks("Hel", "lo"),
+			truncate: 10,
+			err:      ErrStreamIncomplete,
+		},
+		{
+			name: "empty body",
+			err:  ErrStreamIncomplete,
+		},
+		{
+			name:    "error chunk",
+			chunks:  append(contentChunks("Hel")[:1], OllamaChatResponse{Error: "model runner has unexpectedly stopped"}),
+			err:     ErrStreamFailed,
+			message: "model runner has unexpectedly stopped",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := ndjsonServer(t, tt.chunks, nil, tt.truncate)
+			o := newTestOllama(t, srv.URL)
+
+			_, err := o.chat(context.Background(), 0, testChannelID, testChat(), func(chunk *OllamaChatResponse) error {
+				return nil
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Fatalf("expected the reason %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
+
+// A fake channel recording the replies sent and the edits made to them
+type fakeMessageClient struct {
+	nextID  snowflake.ID
+	created []discord.MessageCreate
+	updates []discord.MessageUpdate
+	calls   []string
+}
+
+func (c *fakeMessageClient) CreateMessage(channelID snowflake.ID, messageCreate discord.MessageCreate, opts ...rest.RequestOpt) (*discord.Message, error) {
+	c.calls = append(c.calls, "create")
+	c.created = append(c.created, messageCreate)
+	c.nextID++
+	return &discord.Message{ID: c.nextID, ChannelID: channelID}, nil
+}
+
+func (c *fakeMessageClient) UpdateMessage(channelID snowflake.ID, messageID snowflake.ID, messageUpdate discord.MessageUpdate, opts ...rest.RequestOpt) (*discord.Message, error) {
+	c.calls = append(c.calls, "update")
+	c.updates = append(c.updates, messageUpdate)
+	return &discord.Message{ID: messageID, ChannelID: channelID}, nil
+}
+
+func testReply() discord.Message {
+	return discord.Message{
+		ID:        1000,
+		ChannelID: testChannelID,
+		Author:    discord.User{ID: 2000, Username: "tester"},
+	}
+}
+
+func TestStreamThrottlesEdits(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    func(i int) time.Duration
+		calls    []string
+		previews []string
+	}{
+		{
+			name:  "fast answer",
+			calls: []string{"create", "update"},
+		},
+		{
+			// NOTE:
+			// Only the chunk arriving after the edit interval edits the placeholder, the final edit follows
+			name: "slow answer",
+			delay: func(i int) time.Duration {
+				if i == 2 {
+					return StreamEditInterval + 100*time.Millisecond
+				}
+				return 0
+			},
+			calls:    []string{"create", "update", "update"},
+			previews: []string{"one two three"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := ndjsonServer(t, contentChunks("one", " two", " three", " four"), tt.delay, 0)
+			o := newTestOllama(t, srv.URL)
+			c := &fakeMessageClient{}
+
+			o.stream(context.Background(), c, testReply(), testChat(), ToolContext{ChannelID: testChannelID})
+
+			if strings.Join(c.calls, ",") != strings.Join(tt.calls, ",") {
+				t.Fatalf("expected calls %v, got %v", tt.calls, c.calls)
+			}
+			if c.created[0].Content != StreamPlaceholder {
+				t.Fatalf("expected the placeholder first, got %q", c.created[0].Content)
+			}
+			for i, preview := range tt.previews {
+				if *c.updates[i].Content != preview {
+					t.Fatalf("expected preview %q, got %q", preview, *c.updates[i].Content)
+				}
+			}
+			if final := *c.updates[len(c.updates)-1].Content; final != "one two three four" {
+				t.Fatalf("expected the full answer in the final edit, got %q", final)
+			}
+		})
+	}
+}
+
+func TestStreamSplitsFinalAnswer(t *testing.T) {
+	parts := make([]string, 0, 50)
+	for range 50 {
+		parts = append(parts, strings.Repeat("word ", 10))
+	}
+	answer := strings.Join(parts, "")
+	srv := ndjsonServer(t, contentChunks(parts...), nil, 0)
+	o := newTestOllama(t, srv.URL)
+	c := &fakeMessageClient{}
+
+	o.stream(context.Background(), c, testReply(), testChat(), ToolContext{ChannelID: testChannelID})
+
+	if strings.Join(c.calls, ",") != "create,update,create" {
+		t.Fatalf("expected the placeholder, its final edit and one reply, got %v", c.calls)
+	}
+	first := *c.updates[0].Content
+	second := c.created[1].Content
+	if len([]rune(first)) > MessageMaxLength || len([]rune(second)) > MessageMaxLength {
+		t.Fatalf("messages over the limit: %d and %d", len([]rune(first)), len([]rune(second)))
+	}
+	if strings.Join(strings.Fields(first+" "+second), " ") != strings.Join(strings.Fields(answer), " ") {
+		t.Fatal("the split messages should hold the whole answer")
+	}
+	if ref := c.created[1].MessageReference; ref == nil || *ref.MessageID != 1 {
+		t.Fatalf("the rest should reply to the placeholder, got %+v", ref)
+	}
+}
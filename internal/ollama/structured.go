@@ -0,0 +1,268 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// FormatJSON makes the model answer with any JSON, set a schema instead to fix its shape
+var FormatJSON = json.RawMessage(`"json"`)
+
+const (
+	// NOTE:
+	// Attempts after an answer that doesn't match the schema, small models slip up now and then
+	StructuredRetries = 2
+	// Structured answers are data rather than chat, the playful channel temperature only breaks them
+	StructuredTemperature = 0.2
+)
+
+var ErrStructuredOutput = errors.New("model answer doesn't match the schema")
+
+// A Validator checks a structured answer beyond its schema, like the length of a list
+type Validator interface {
+	Validate() error
+}
+
+var (
+	typeTime      = reflect.TypeFor[time.Time]()
+	typeSnowflake = reflect.TypeFor[snowflake.ID]()
+)
+
+// Schema builds the JSON schema of T for structured outputs.
+// Fields are named by their json tag and required unless tagged omitempty or omitzero.
+// The description tag describes a field to the model, and the enum tag lists the allowed values of a string separated by commas.
+func Schema[T any]() json.RawMessage {
+	data, _ := json.Marshal(schemaOf(reflect.TypeFor[T]()))
+	return data
+}
+
+func schemaOf(t reflect.Type) map[string]any {
+	return typeSchema(t, map[reflect.Type]bool{})
+}
+
+// Builds the schema of the type, path holds the structs it is nested in
+func typeSchema(t reflect.Type, path map[reflect.Type]bool) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case typeTime:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case typeSnowflake:
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string"}
+		}
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), path)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), path)}
+	case reflect.Struct:
+		// NOTE:
+		// The schema of a struct containing itself, like a tree, would never end.
+		// Where it repeats any value is allowed instead.
+		if path[t] {
+			return map[string]any{}
+		}
+		path[t] = true
+		defer delete(path, t)
+
+		properties := map[string]any{}
+		required := []string{}
+		structFields(t, path, properties, &required)
+		return map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	}
+	return map[string]any{}
+}
+
+// Adds the fields of the struct to the schema, embedded structs are flattened like encoding/json does
+func structFields(t reflect.Type, path map[reflect.Type]bool, properties map[string]any, required *[]string) {
+	for field := range t.Fields() {
+		tag := field.Tag.Get("json")
+		if tag == "-" || !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if !path[embedded] {
+					path[embedded] = true
+					structFields(embedded, path, properties, required)
+					delete(path, embedded)
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := typeSchema(field.Type, path)
+		if description := field.Tag.Get("description"); description != "" {
+			schema["description"] = description
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			schema["enum"] = strings.Split(enum, ",")
+		}
+		properties[name] = schema
+
+		optional := slices.ContainsFunc(strings.Split(opts, ","), func(opt string) bool {
+			return opt == "omitempty" || opt == "omitzero"
+		})
+		if !optional {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// Checks the required fields and enums of the schema, which not every backend enforces
+func validateSchema(schema map[string]any, value any, path string) error {
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if _, ok := value[name]; !ok {
+				return fmt.Errorf("%s is missing the required field %s", schemaPath(path), name)
+			}
+		}
+		for name, field := range value {
+			fieldSchema, ok := properties[name].(map[string]any)
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(map[string]any); ok {
+					fieldSchema = additional
+				} else {
+					continue
+				}
+			}
+			err := validateSchema(fieldSchema, field, path+"."+name)
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range value {
+			err := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+	case string:
+		if enum, ok := schema["enum"].([]string); ok && !slices.Contains(enum, value) {
+			return fmt.Errorf("%s must be one of %s, got %q", schemaPath(path), strings.Join(enum, ", "), value)
+		}
+	}
+	return nil
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "the answer"
+	}
+	return strings.TrimPrefix(path, ".")
+}
+
+// Decodes and validates a structured answer
+func decodeStructured[T any](schema map[string]any, content string) (T, error) {
+	var out T
+
+	var raw any
+	err := json.Unmarshal([]byte(content), &raw)
+	if err != nil {
+		return out, fmt.Errorf("%w: %w", ErrStructuredOutput, err)
+	}
+	err = validateSchema(schema, raw, "")
+	if err != nil {
+		return out, fmt.Errorf("%w: %w", ErrStructuredOutput, err)
+	}
+
+	err = json.Unmarshal([]byte(content), &out)
+	if err != nil {
+		return out, fmt.Errorf("%w: %w", ErrStructuredOutput, err)
+	}
+	if validator, ok := any(out).(Validator); ok {
+		err = validator.Validate()
+		if err != nil {
+			return out, fmt.Errorf("%w: %w", ErrStructuredOutput, err)
+		}
+	}
+	return out, nil
+}
+
+// Structured asks the model of the channel for an answer shaped like T, see Schema.
+// An answer that doesn't decode or validate is sent back to the model with the problem, up to StructuredRetries times.
+func Structured[T any](ctx context.Context, o *Ollama, guildID snowflake.ID, channelID snowflake.ID, messages []OllamaChatMessage) (T, error) {
+	schema := schemaOf(reflect.TypeFor[T]())
+
+	chat := OllamaChat{
+		Model:    o.model(guildID, channelID),
+		Messages: slices.Clone(messages),
+	}
+	o.applyOptions(&chat, guildID, channelID)
+	chat.Options.Temperature = new(float64(StructuredTemperature))
+	chat.Format = Schema[T]()
+	numCtx := o.modelConfig(guildID, channelID).NumCtx
+
+	var out T
+	var err error
+	for attempt := range StructuredRetries + 1 {
+		// NOTE:
+		// Every rejected answer and its correction are added to the chat, so it is fitted again each time
+		o.fit(&chat, numCtx)
+
+		var res *OllamaChatResponse
+		res, err = o.chat(ctx, guildID, channelID, chat, nil)
+		if err != nil {
+			return out, err
+		}
+
+		out, err = decodeStructured[T](schema, res.Message.Content)
+		if err == nil {
+			return out, nil
+		}
+		o.logger.Debug("Structured answer rejected", slog.Int("attempt", attempt+1), slog.Any("error", err))
+
+		// NOTE:
+		// Only the answer goes back, the reasoning behind it would take up the context for nothing
+		answer := res.Message
+		answer.Thinking = ""
+		chat.Messages = append(chat.Messages, answer, OllamaChatMessage{
+			Role:    OllamaChatMessageRoleUser,
+			Content: fmt.Sprintf("That answer is invalid, %s. Answer again with only JSON matching the schema.", err),
+		})
+	}
+	return out, err
+}
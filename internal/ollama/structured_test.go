@@ -0,0 +1,152 @@
+package ollama
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+type testBase struct {
+	ID snowflake.ID `json:"id"`
+}
+
+type testAnswer struct {
+	testBase
+	Mood    string    `json:"mood" enum:"happy,sad" description:"how the channel feels"`
+	Topics  []string  `json:"topics"`
+	Score   float64   `json:"score,omitempty"`
+	At      time.Time `json:"at,omitzero"`
+	Ignored string    `json:"-"`
+	hidden  string
+}
+
+type testNode struct {
+	Name     string     `json:"name"`
+	Children []testNode `json:"children,omitempty"`
+	Parent   *testNode  `json:"parent,omitempty"`
+}
+
+type testValidated struct {
+	Topics []string `json:"topics"`
+}
+
+func (v testValidated) Validate() error {
+	if len(v.Topics) > 2 {
+		return errors.New("at most 2 topics")
+	}
+	return nil
+}
+
+// Decodes the schema of T back into a map, as sent to the model
+func testSchema[T any](t *testing.T) map[string]any {
+	t.Helper()
+	var schema map[string]any
+	err := json.Unmarshal(Schema[T](), &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestSchema(t *testing.T) {
+	schema := testSchema[testAnswer](t)
+
+	properties := schema["properties"].(map[string]any)
+	if len(properties) != 5 {
+		t.Fatalf("expected the embedded id and 4 fields, got %v", properties)
+	}
+	required := schema["required"].([]any)
+	if !reflect.DeepEqual(required, []any{"id", "mood", "topics"}) {
+		t.Fatalf("expected id, mood and topics to be required, got %v", required)
+	}
+	if schema["additionalProperties"] != false {
+		t.Fatal("expected no additional properties")
+	}
+
+	mood := properties["mood"].(map[string]any)
+	if mood["description"] != "how the channel feels" || !reflect.DeepEqual(mood["enum"], []any{"happy", "sad"}) {
+		t.Fatalf("expected the description and enum of the tags, got %v", mood)
+	}
+	if at := properties["at"].(map[string]any); at["format"] != "date-time" {
+		t.Fatalf("expected times as date-time strings, got %v", at)
+	}
+	if id := properties["id"].(map[string]any); id["type"] != "string" {
+		t.Fatalf("expected snowflakes as strings, got %v", id)
+	}
+	if topics := properties["topics"].(map[string]any); topics["type"] != "array" || topics["items"].(map[string]any)["type"] != "string" {
+		t.Fatalf("expected a list of strings, got %v", topics)
+	}
+}
+
+func TestSchemaRecursive(t *testing.T) {
+	// NOTE:
+	// Used to recurse until the stack ran out
+	schema := testSchema[testNode](t)
+
+	properties := schema["properties"].(map[string]any)
+	children := properties["children"].(map[string]any)
+	if children["type"] != "array" || len(children["items"].(map[string]any)) != 0 {
+		t.Fatalf("expected the repeated node to allow any value, got %v", children)
+	}
+	if parent := properties["parent"].(map[string]any); len(parent) != 0 {
+		t.Fatalf("expected the repeated node to allow any value, got %v", parent)
+	}
+}
+
+func TestDecodeStructured(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string // part of the error, empty if the answer is valid
+	}{
+		{
+			name:    "valid",
+			content: `{"id":"1","mood":"happy","topics":["music"]}`,
+		},
+		{
+			name:    "invalid json",
+			content: `{"mood":"happy"`,
+			err:     "unexpected end of JSON input",
+		},
+		{
+			name:    "missing required field",
+			content: `{"id":"1","mood":"happy"}`,
+			err:     "the answer is missing the required field topics",
+		},
+		{
+			name:    "value not in the enum",
+			content: `{"id":"1","mood":"angry","topics":[]}`,
+			err:     `mood must be one of happy, sad, got "angry"`,
+		},
+	}
+	schema := schemaOf(reflect.TypeFor[testAnswer]())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := decodeStructured[testAnswer](schema, tt.content)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if out.ID != 1 || out.Mood != "happy" || len(out.Topics) != 1 {
+					t.Fatalf("unexpected answer %+v", out)
+				}
+				return
+			}
+			if !errors.Is(err, ErrStructuredOutput) || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	t.Run("validator", func(t *testing.T) {
+		_, err := decodeStructured[testValidated](schemaOf(reflect.TypeFor[testValidated]()), `{"topics":["a","b","c"]}`)
+		if !errors.Is(err, ErrStructuredOutput) || !strings.Contains(err.Error(), "at most 2 topics") {
+			t.Fatalf("expected the validator to reject the answer, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package ollama
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	DefaultSummarizeMessages = 100
+	MaxSummarizeMessages     = 1000
+	// NOTE:
+	// Tokens kept free in every request for the instructions and the answer
+	summarizeReserve = 2 * DefaultResponseTokens
+
+	summarizeChunkPrompt = "You take notes on part of a Discord conversation for a summary of the whole conversation. " +
+		"Write short notes on the topics discussed, the decisions made and the questions left open, naming who said what. " +
+		"Only write the notes."
+	summarizePrompt = "You summarize Discord conversations for people who missed them. " +
+		"You are given the conversation, or notes on its parts in order. " +
+		"Keep it short and factual, and use the names of the people as they appear."
+)
+
+var ErrNothingToSummarize = errors.New("no messages to summarize")
+
+// SummarizeOptions picks the messages to summarize, the most recent ones are taken
+type SummarizeOptions struct {
+	Messages int       // max messages to summarize, defaults to DefaultSummarizeMessages or MaxSummarizeMessages if Since is set
+	Since    time.Time // only summarize the messages sent after this time
+}
+
+// A Summary of a channel, as answered by the model
+type Summary struct {
+	Overview     string               `json:"overview" description:"what the conversation was about in one or two sentences"`
+	Topics       []SummaryTopic       `json:"topics" description:"the topics discussed, in order"`
+	Decisions    []string             `json:"decisions" description:"what was decided or agreed on, empty if nothing was"`
+	Participants []SummaryParticipant `json:"participants" description:"what each person contributed"`
+	// the messages summarized
+	Messages int       `json:"-"`
+	From     time.Time `json:"-"`
+}
+
+type SummaryTopic struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+}
+
+type SummaryParticipant struct {
+	Name         string `json:"name"`
+	Contribution string `json:"contribution"`
+}
+
+// Fetches the messages to summarize, newest first
+func (o *Ollama) summaryHistory(client *bot.Client, channelID snowflake.ID, opts SummarizeOptions) ([]discord.Message, error) {
+	limit := opts.Messages
+	if limit <= 0 {
+		limit = DefaultSummarizeMessages
+		if !opts.Since.IsZero() {
+			limit = MaxSummarizeMessages
+		}
+	}
+	limit = min(limit, MaxSummarizeMessages)
+
+	// NOTE:
+	// Discord returns at most 100 messages per request, so the history is fetched page by page
+	msgs := make([]discord.Message, 0, limit)
+	var before snowflake.ID
+	for len(msgs) < limit {
+		count := min(limit-len(msgs), MaxContextMessages)
+		page, err := client.Rest.GetMessages(channelID, 0, before, 0, count)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, msg := range page {
+			if msg.CreatedAt.Before(opts.Since) {
+				return msgs, nil
+			}
+			msgs = append(msgs, msg)
+		}
+		if len(page) < count {
+			break
+		}
+		before = page[len(page)-1].ID
+	}
+	return msgs, nil
+}
+
+// Turns the messages into transcript lines, oldest first
+func transcript(client *bot.Client, msgs []discord.Message) []string {
+	lines := make([]string, 0, len(msgs))
+	for _, msg := range slices.Backward(msgs) {
+		content := resolveMentions(client, msg)
+		if len(msg.Attachments) > 0 {
+			content = strings.TrimSpace(fmt.Sprintf("%s [%d attachments]", content, len(msg.Attachments)))
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", msg.CreatedAt.UTC().Format("2006-01-02 15:04"), authorName(client, msg), content))
+	}
+	return lines
+}
+
+// Groups the texts into chunks of roughly at most budget tokens, a longer text gets a chunk of its own
+func chunkTexts(texts []string, sep string, budget int) []string {
+	var chunks []string
+	var chunk strings.Builder
+	used := 0
+	for _, text := range texts {
+		tokens := countTokens(OllamaChatMessage{Content: text})
+		if chunk.Len() > 0 && used+tokens > budget {
+			chunks = append(chunks, chunk.String())
+			chunk.Reset()
+			used = 0
+		}
+		if chunk.Len() > 0 {
+			chunk.WriteString(sep)
+		}
+		chunk.WriteString(text)
+		used += tokens
+	}
+	if chunk.Len() > 0 {
+		chunks = append(chunks, chunk.String())
+	}
+	return chunks
+}
+
+// Takes notes on a part of the conversation
+func (o *Ollama) summarizeChunk(ctx context.Context, guildID snowflake.ID, channelID snowflake.ID, text string, numCtx int) (string, error) {
+	chat := OllamaChat{
+		Model: o.model(guildID, channelID),
+		Messages: []OllamaChatMessage{
+			{Role: OllamaChatMessageRoleSystem, Content: summarizeChunkPrompt},
+			{Role: OllamaChatMessageRoleUser, Content: text},
+		},
+	}
+	o.applyOptions(&chat, guildID, channelID)
+	// NOTE:
+	// The notes feed the final summary, so they are kept as plain as its structured answer
+	chat.Options.Temperature = new(float64(StructuredTemperature))
+	chat.Format = nil
+	o.fit(&chat, numCtx)
+
+	res, err := o.chat(ctx, guildID, channelID, chat, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(res.Message.Content), nil
+}
+
+// Summarize summarizes the recent messages of the channel.
+// Conversations too long for the context window of the model are summarized in parts first, and then the notes on the parts.
+func (o *Ollama) Summarize(ctx context.Context, client *bot.Client, guildID snowflake.ID, channelID snowflake.ID, user discord.User, opts SummarizeOptions) (Summary, error) {
+	if wait := o.limits.allow(user.ID, channelID, guildID); wait > 0 {
+		return Summary{}, fmt.Errorf("%w, try again in %s", ErrRateLimited, max(wait, time.Second).Round(time.Second))
+	}
+	release, err := o.limits.acquire(ctx)
+	if err != nil {
+		return Summary{}, err
+	}
+	defer release()
+
+	msgs, err := o.summaryHistory(client, channelID, opts)
+	if err != nil {
+		return Summary{}, err
+	}
+	// NOTE:
+	// The answers of the bot are left out, the summary is about what people said
+	msgs = slices.DeleteFunc(stripReasoning(msgs), func(msg discord.Message) bool {
+		return msg.Author.System || msg.Author.ID == client.ID() || (msg.Content == "" && len(msg.Attachments) == 0)
+	})
+	if len(msgs) == 0 {
+		return Summary{}, ErrNothingToSummarize
+	}
+
+	numCtx := cmp.Or(o.modelConfig(guildID, channelID).NumCtx, DefaultNumCtx)
+	budget := max(numCtx-summarizeReserve, DefaultResponseTokens)
+
+	texts := chunkTexts(transcript(client, msgs), "\n", budget)
+	for len(texts) > 1 {
+		o.logger.Debug("Summarizing in parts", slog.Any("channel_id", channelID), slog.Int("parts", len(texts)))
+
+		notes := make([]string, 0, len(texts))
+		for _, text := range texts {
+			note, err := o.summarizeChunk(ctx, guildID, channelID, text, numCtx)
+			if err != nil {
+				return Summary{}, err
+			}
+			notes = append(notes, note)
+		}
+
+		// NOTE:
+		// Notes that don't get shorter would never fit, the context window cuts them instead
+		next := chunkTexts(notes, "\n\n", budget)
+		if len(next) >= len(texts) {
+			next = []string{strings.Join(notes, "\n\n")}
+		}
+		texts = next
+	}
+
+	summary, err := Structured[Summary](ctx, o, guildID, channelID, []OllamaChatMessage{
+		{Role: OllamaChatMessageRoleSystem, Content: summarizePrompt},
+		{Role: OllamaChatMessageRoleUser, Content: texts[0]},
+	})
+	if err != nil {
+		return Summary{}, err
+	}
+
+	summary.Messages = len(msgs)
+	summary.From = msgs[len(msgs)-1].CreatedAt
+	return summary, nil
+}
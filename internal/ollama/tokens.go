@@ -0,0 +1,69 @@
+package ollama
+
+import (
+	"log/slog"
+	"unicode/utf8"
+)
+
+const (
+	DefaultNumCtx = 4096
+	// NOTE:
+	// Tokens kept free for the answer when the request doesn't limit it
+	DefaultResponseTokens = 512
+	// Rough cost of an image for common vision encoders
+	imageTokens = 768
+)
+
+// Approximates the tokens of a message at roughly four characters per token,
+// plus a few for the role and message framing
+func countTokens(msg OllamaChatMessage) int {
+	return (utf8.RuneCountInString(msg.Content)+3)/4 + 4 + len(msg.Images)*imageTokens
+}
+
+// Fits the chat into the context window of the model.
+// System prompts and the latest message are always kept, the oldest turns in between are dropped first.
+func (o *Ollama) fit(chat *OllamaChat, numCtx int) {
+	if numCtx <= 0 {
+		numCtx = DefaultNumCtx
+	}
+	chat.Options.NumCtx = numCtx
+
+	reserve := DefaultResponseTokens
+	if chat.Options.NumPredict > 0 {
+		reserve = chat.Options.NumPredict
+	}
+	budget := numCtx - reserve
+
+	used := 0
+	for _, msg := range chat.Messages {
+		used += countTokens(msg)
+	}
+	if used <= budget {
+		return
+	}
+
+	kept := make([]OllamaChatMessage, 0, len(chat.Messages))
+	dropped, droppedTokens := 0, 0
+	last := len(chat.Messages) - 1
+	for i, msg := range chat.Messages {
+		if used > budget && i != last && msg.Role != OllamaChatMessageRoleSystem {
+			tokens := countTokens(msg)
+			used -= tokens
+			dropped++
+			droppedTokens += tokens
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	chat.Messages = kept
+
+	o.logger.Debug("Dropped messages to fit the context window",
+		slog.Int("dropped", dropped),
+		slog.Int("dropped_tokens", droppedTokens),
+		slog.Int("tokens", used),
+		slog.Int("num_ctx", numCtx),
+	)
+	if used > budget {
+		o.logger.Warn("Chat exceeds the context window", slog.Int("tokens", used), slog.Int("num_ctx", numCtx))
+	}
+}
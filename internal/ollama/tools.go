@@ -0,0 +1,316 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	DefaultToolIterations  = 5
+	DefaultToolTimeout     = 60 * time.Second
+	DefaultToolCallTimeout = 15 * time.Second // max time of a single tool call
+)
+
+// Where a tool is being called from
+type ToolContext struct {
+	Client    *bot.Client
+	GuildID   *snowflake.ID // nil in DMs
+	ChannelID snowflake.ID
+	User      discord.User
+	Member    *discord.Member // the user in the guild, as sent with the message. nil in DMs
+}
+
+// Get the guild and channel, the guild is 0 in DMs
+func (tc ToolContext) location() (snowflake.ID, snowflake.ID) {
+	if tc.GuildID == nil {
+		return 0, tc.ChannelID
+	}
+	return *tc.GuildID, tc.ChannelID
+}
+
+// A ToolHandler runs a tool with the arguments chosen by the model.
+// The returned string is fed back to the model as the tool result.
+type ToolHandler func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error)
+
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage // JSON schema of the arguments, nil for tools without arguments
+	Handler     ToolHandler
+}
+
+type ToolRegistry struct {
+	tools map[string]Tool
+	m     sync.RWMutex
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		tools: make(map[string]Tool),
+	}
+}
+
+// Register adds a tool, replacing any tool with the same name
+func (r *ToolRegistry) Register(tools ...Tool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, tool := range tools {
+		r.tools[tool.Name] = tool
+	}
+}
+
+// Definitions returns the tools in the format expected by the chat endpoint
+func (r *ToolRegistry) Definitions() []OllamaChatTools {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	defs := make([]OllamaChatTools, 0, len(r.tools))
+	for _, tool := range r.tools {
+		params := tool.Parameters
+		if params == nil {
+			params = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		defs = append(defs, OllamaChatTools{
+			Type: "function",
+			Function: OllamaChatToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  params,
+			},
+		})
+	}
+
+	// NOTE:
+	// Keep the order stable so identical requests hit the same prompt cache
+	slices.SortFunc(defs, func(a, b OllamaChatTools) int {
+		return strings.Compare(a.Function.Name, b.Function.Name)
+	})
+	return defs
+}
+
+// Call runs the requested tool and wraps the result in a tool message.
+// Failures are reported back to the model instead of aborting the chat.
+func (r *ToolRegistry) Call(ctx context.Context, tc ToolContext, call OllamaChatToolCalls) OllamaChatMessage {
+	name := call.Function.Name
+
+	r.m.RLock()
+	tool, ok := r.tools[name]
+	r.m.RUnlock()
+
+	var content string
+	if !ok {
+		content = fmt.Sprintf("error: unknown tool %q", name)
+	} else {
+		res, err := tool.Handler(ctx, tc, call.Function.Arguments)
+		if err != nil {
+			content = fmt.Sprintf("error: %s", err)
+		} else {
+			content = res
+		}
+	}
+
+	return OllamaChatMessage{
+		Role:     OllamaChatMessageRoleTool,
+		Content:  content,
+		ToolName: name,
+	}
+}
+
+// Chats with the model, running the tools it asks for until it answers.
+// If onChunk is set the responses are streamed.
+func (o *Ollama) ChatTools(ctx context.Context, chat OllamaChat, tc ToolContext, onChunk OllamaChatStreamHandler) (*OllamaChatResponse, error) {
+	iterations := DefaultToolIterations
+	timeout := DefaultToolTimeout
+	if cfg := o.cfg.Tools; cfg != nil {
+		if cfg.MaxIterations > 0 {
+			iterations = cfg.MaxIterations
+		}
+		if cfg.Timeout > 0 {
+			timeout = cfg.Timeout
+		}
+	}
+
+	// NOTE:
+	// The timeout only applies to the tools, so the final turn can still be requested
+	toolCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	chat.Tools = o.Tools.Definitions()
+	chat.Messages = slices.Clone(chat.Messages)
+
+	guildID, channelID := tc.location()
+	numCtx := o.modelConfig(guildID, channelID).NumCtx
+	for i := 0; ; i++ {
+		// NOTE:
+		// Tool calls and their results grow the conversation every turn, so it is fitted again each time
+		o.fit(&chat, numCtx)
+		res, err := o.chatTurn(ctx, guildID, channelID, chat, onChunk)
+		if err != nil || len(res.Message.ToolCalls) == 0 || chat.Tools == nil {
+			return res, err
+		}
+
+		// NOTE:
+		// Once we run out of iterations or time, the model gets one last turn
+		// without tools so it has to answer with what it has
+		if i >= iterations || toolCtx.Err() != nil {
+			o.logger.Warn("Tool calling limit reached", slog.Int("iterations", i), slog.Any("error", toolCtx.Err()))
+			chat.Tools = nil
+			continue
+		}
+
+		chat.Messages = append(chat.Messages, res.Message)
+		for _, call := range res.Message.ToolCalls {
+			o.logger.Debug("Calling tool", slog.String("tool", call.Function.Name), slog.String("arguments", string(call.Function.Arguments)))
+			chat.Messages = append(chat.Messages, o.callTool(toolCtx, tc, call))
+		}
+	}
+}
+
+// Runs a single turn of the tool loop, bound by the time of a single request
+// so a stuck backend can't hold the loop forever. Retries and fallbacks are included.
+func (o *Ollama) chatTurn(ctx context.Context, guildID snowflake.ID, channelID snowflake.ID, chat OllamaChat, onChunk OllamaChatStreamHandler) (*OllamaChatResponse, error) {
+	timeout := o.cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return o.chat(ctx, guildID, channelID, chat, onChunk)
+}
+
+// Runs a single tool call, a slow tool only uses up its own time
+func (o *Ollama) callTool(ctx context.Context, tc ToolContext, call OllamaChatToolCalls) OllamaChatMessage {
+	ctx, cancel := context.WithTimeout(ctx, DefaultToolCallTimeout)
+	defer cancel()
+	return o.Tools.Call(ctx, tc, call)
+}
+
+// -- BUILTIN TOOLS --
+
+// Get the name of the server owner, who might not be cached
+func ownerName(ctx context.Context, tc ToolContext, ownerID snowflake.ID) string {
+	if member, ok := tc.Client.Caches.Member(*tc.GuildID, ownerID); ok {
+		return member.EffectiveName()
+	}
+	member, err := tc.Client.Rest.GetMember(*tc.GuildID, ownerID, rest.WithCtx(ctx))
+	if err != nil {
+		return "unknown"
+	}
+	return member.EffectiveName()
+}
+
+var builtinTools = []Tool{
+	{
+		Name:        "current_time",
+		Description: "Get the current date and time",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"timezone": {
+					"type": "string",
+					"description": "IANA timezone name, for example Europe/Oslo. Defaults to UTC"
+				}
+			}
+		}`),
+		Handler: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			var params struct {
+				Timezone string `json:"timezone"`
+			}
+			if len(args) > 0 {
+				err := json.Unmarshal(args, &params)
+				if err != nil {
+					return "", err
+				}
+			}
+
+			loc := time.UTC
+			if params.Timezone != "" {
+				var err error
+				loc, err = time.LoadLocation(params.Timezone)
+				if err != nil {
+					return "", fmt.Errorf("unknown timezone %q", params.Timezone)
+				}
+			}
+
+			return time.Now().In(loc).Format("Monday 2 January 2006 15:04:05 MST"), nil
+		},
+	},
+	{
+		Name:        "server_info",
+		Description: "Get information about the Discord server the conversation takes place in",
+		Handler: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			if tc.GuildID == nil {
+				return "", fmt.Errorf("the conversation is not in a server")
+			}
+
+			guild, ok := tc.Client.Caches.Guild(*tc.GuildID)
+			if !ok {
+				return "", fmt.Errorf("server not found")
+			}
+
+			var b strings.Builder
+			fmt.Fprintf(&b, "Name: %s\n", guild.Name)
+			if guild.Description != nil {
+				fmt.Fprintf(&b, "Description: %s\n", *guild.Description)
+			}
+			// NOTE:
+			// A mention would ping the owner once the model repeats it
+			fmt.Fprintf(&b, "Owner: %s\n", ownerName(ctx, tc, guild.OwnerID))
+			fmt.Fprintf(&b, "Members: %d\n", guild.MemberCount)
+			fmt.Fprintf(&b, "Created: %s\n", guild.CreatedAt().Format(time.DateOnly))
+			if channel, ok := tc.Client.Caches.Channel(tc.ChannelID); ok {
+				fmt.Fprintf(&b, "Current channel: #%s\n", channel.Name())
+			}
+			return b.String(), nil
+		},
+	},
+	{
+		Name:        "voice_members",
+		Description: "List who is currently in the voice channels of the Discord server",
+		Handler: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			if tc.GuildID == nil {
+				return "", fmt.Errorf("the conversation is not in a server")
+			}
+
+			caches := tc.Client.Caches
+			channels := make(map[snowflake.ID][]string)
+			for vs := range caches.VoiceStates(*tc.GuildID) {
+				if vs.ChannelID == nil {
+					continue
+				}
+
+				name := vs.UserID.String()
+				if member, ok := caches.Member(*tc.GuildID, vs.UserID); ok {
+					name = member.EffectiveName()
+				}
+				channels[*vs.ChannelID] = append(channels[*vs.ChannelID], name)
+			}
+
+			if len(channels) == 0 {
+				return "Nobody is in a voice channel", nil
+			}
+
+			var b strings.Builder
+			for channelID, names := range channels {
+				channelName := channelID.String()
+				if channel, ok := caches.Channel(channelID); ok {
+					channelName = channel.Name()
+				}
+				fmt.Fprintf(&b, "%s: %s\n", channelName, strings.Join(names, ", "))
+			}
+			return b.String(), nil
+		},
+	},
+}
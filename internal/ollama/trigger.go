@@ -0,0 +1,159 @@
+package ollama
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Akvanvig/roboto-go/internal/config"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const DefaultTriggerPhrase = "hey chat"
+
+// The trigger rules of a channel, resolved along the prompt chain
+type trigger struct {
+	phrases        []string
+	patterns       []*regexp.Regexp
+	mention        bool
+	reply          bool
+	directMessages bool
+	always         bool
+	probability    float64
+	bots           bool
+	ignoreUsers    []snowflake.ID
+	ignoreRoles    []snowflake.ID
+	ignoreChannels []snowflake.ID
+}
+
+// Compiles the trigger patterns of every prompt config, so invalid ones are caught at startup
+func compilePatterns(cfg *config.OllamaConfig) (map[string]*regexp.Regexp, error) {
+	triggers := []*config.OllamaTriggerConfig{cfg.DefaultPrompt.Trigger}
+	for _, prompt := range cfg.ServerPrompts {
+		triggers = append(triggers, prompt.Trigger)
+	}
+	for _, prompt := range cfg.ChannelPrompts {
+		triggers = append(triggers, prompt.Trigger)
+	}
+	for _, prompt := range cfg.Personas {
+		triggers = append(triggers, prompt.Trigger)
+	}
+
+	patterns := make(map[string]*regexp.Regexp)
+	for _, t := range triggers {
+		if t == nil {
+			continue
+		}
+		for _, pattern := range t.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trigger pattern %q: %w", pattern, err)
+			}
+			patterns[pattern] = re
+		}
+	}
+	return patterns, nil
+}
+
+// Get the trigger rules, the most specific config wins field by field.
+// The channel persona replaces the channel config, like it does for the prompt.
+// Direct messages have no guild, and only use the default config.
+func (o *Ollama) trigger(guildID snowflake.ID, channelID snowflake.ID) trigger {
+	t := trigger{
+		phrases: []string{DefaultTriggerPhrase},
+		reply:   true,
+	}
+
+	cfgs := []*config.OllamaTriggerConfig{o.cfg.DefaultPrompt.Trigger}
+	if guildID != 0 {
+		cfgs = append(cfgs, o.serverPrompt(guildID).Trigger, o.channelPrompt(channelID).Trigger)
+	}
+
+	for _, cfg := range cfgs {
+		if cfg == nil {
+			continue
+		}
+		if cfg.Phrases != nil {
+			t.phrases = cfg.Phrases
+		}
+		if cfg.Patterns != nil {
+			t.patterns = make([]*regexp.Regexp, 0, len(cfg.Patterns))
+			for _, pattern := range cfg.Patterns {
+				t.patterns = append(t.patterns, o.patterns[pattern])
+			}
+		}
+		if cfg.Mention != nil {
+			t.mention = *cfg.Mention
+		}
+		if cfg.Reply != nil {
+			t.reply = *cfg.Reply
+		}
+		if cfg.DirectMessages != nil {
+			t.directMessages = *cfg.DirectMessages
+		}
+		if cfg.Always != nil {
+			t.always = *cfg.Always
+		}
+		if cfg.Probability != nil {
+			t.probability = *cfg.Probability
+		}
+		if cfg.Bots != nil {
+			t.bots = *cfg.Bots
+		}
+		t.ignoreUsers = append(t.ignoreUsers, cfg.IgnoreUsers...)
+		t.ignoreRoles = append(t.ignoreRoles, cfg.IgnoreRoles...)
+		t.ignoreChannels = append(t.ignoreChannels, cfg.IgnoreChannels...)
+	}
+
+	return t
+}
+
+// Whether the message should be answered by the bot
+func (t trigger) matches(msg discord.Message, botID snowflake.ID) bool {
+	// NOTE:
+	// Two bots answering each other would never stop
+	if msg.Author.Bot && !t.bots {
+		return false
+	}
+	if slices.Contains(t.ignoreUsers, msg.Author.ID) || slices.Contains(t.ignoreChannels, msg.ChannelID) {
+		return false
+	}
+	if msg.Member != nil && slices.ContainsFunc(msg.Member.RoleIDs, func(roleID snowflake.ID) bool {
+		return slices.Contains(t.ignoreRoles, roleID)
+	}) {
+		return false
+	}
+
+	if msg.GuildID == nil {
+		return t.directMessages
+	}
+	if t.always {
+		return true
+	}
+
+	if t.reply && msg.ReferencedMessage != nil && msg.ReferencedMessage.Author.ID == botID {
+		return true
+	}
+	if t.mention && slices.ContainsFunc(msg.Mentions, func(user discord.User) bool {
+		return user.ID == botID
+	}) {
+		return true
+	}
+
+	content := strings.ToLower(msg.Content)
+	for _, phrase := range t.phrases {
+		if phrase != "" && strings.Contains(content, strings.ToLower(phrase)) {
+			return true
+		}
+	}
+	for _, pattern := range t.patterns {
+		if pattern.MatchString(msg.Content) {
+			return true
+		}
+	}
+
+	return t.probability > 0 && rand.Float64() < t.probability
+}
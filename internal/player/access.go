@@ -0,0 +1,111 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgolink/v3/lavalink"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// See https://github.com/lavalink-devs/youtube-source/blob/ae2b8b316bcd2b2188652d682d2f7fb7dcbbcfd3/common/src/main/java/dev/lavalink/youtube/YoutubeAudioSourceManager.java#L42
+var RegexpYoutubeURL = regexp.MustCompile("^(?:http://|https://|)(?:www\\.|m\\.|music\\.|)youtube\\.com/.*")
+var RegexpYoutubeURLAlt = regexp.MustCompile("^(?:http://|https://|)(?:(?:www\\.|m\\.|music\\.|)youtube\\.com/(?:live|embed|shorts)|(?:www\\.|)youtu\\.be)/(?<videoId>.*)")
+
+var (
+	ErrNotPlaying        = errors.New("no music is currently playing")
+	ErrNotInVoice        = errors.New("must be in a voice channel to queue songs")
+	ErrWrongVoiceChannel = errors.New("must be in the same voice channel as the bot to interact with it")
+)
+
+// Query turns a search into a lavalink identifier, falling back to the guild's default source
+func (p *Player) Query(guildID snowflake.ID, query string, source lavalink.SearchType) string {
+	if source == "" {
+		source = p.Settings(guildID).Source
+	}
+
+	switch source {
+	case lavalink.SearchTypeSoundCloud, lavalink.SearchTypeYouTubeMusic:
+		return source.Apply(query)
+	default:
+		// If the query is a direct link, we just send the url directly
+		if !RegexpYoutubeURL.MatchString(query) && !RegexpYoutubeURLAlt.MatchString(query) {
+			return lavalink.SearchTypeYouTube.Apply(query)
+		}
+	}
+
+	return query
+}
+
+// CheckJoin returns the voice channel of the user if they are allowed to queue music from it
+func (p *Player) CheckJoin(guildID snowflake.ID, userID snowflake.ID) (snowflake.ID, error) {
+	caches := p.discord.Caches
+
+	vsUser, ok := caches.VoiceState(guildID, userID)
+	if !ok || vsUser.ChannelID == nil {
+		return 0, ErrNotInVoice
+	}
+
+	vsBot, ok := caches.VoiceState(guildID, p.discord.ApplicationID)
+	if ok && vsBot.ChannelID != nil && *vsBot.ChannelID != *vsUser.ChannelID {
+		return 0, ErrWrongVoiceChannel
+	}
+
+	return *vsUser.ChannelID, nil
+}
+
+// Join connects the bot to the voice channel unless it is already in one
+func (p *Player) Join(ctx context.Context, guildID snowflake.ID, channelID snowflake.ID) error {
+	vsBot, ok := p.discord.Caches.VoiceState(guildID, p.discord.ApplicationID)
+	if ok && vsBot.ChannelID != nil {
+		if *vsBot.ChannelID != channelID {
+			return ErrWrongVoiceChannel
+		}
+		return nil
+	}
+
+	return p.discord.UpdateVoiceState(ctx, guildID, &channelID, false, false)
+}
+
+// CheckDJ verifies that a member has the DJ role of the guild, if one is set. Server managers always pass.
+func (p *Player) CheckDJ(guildID snowflake.ID, member discord.ResolvedMember) error {
+	settings := p.Settings(guildID)
+	if settings.DJRoleID != 0 {
+		if !slices.Contains(member.RoleIDs, settings.DJRoleID) && member.Permissions.Missing(discord.PermissionManageGuild) {
+			return fmt.Errorf("only members with the %s role can control the music", discord.RoleMention(settings.DJRoleID))
+		}
+	}
+	return nil
+}
+
+// CheckControl verifies that a member may control the music playing in the guild from the given text channel
+func (p *Player) CheckControl(guildID snowflake.ID, textChannelID snowflake.ID, member discord.ResolvedMember) error {
+	err := p.CheckDJ(guildID, member)
+	if err != nil {
+		return err
+	}
+
+	channelID := p.ChannelID(guildID)
+	if channelID == nil {
+		return ErrNotPlaying
+	}
+
+	if *channelID != textChannelID {
+		return fmt.Errorf("expecting music interactions in the %s channel", discord.ChannelMention(*channelID))
+	}
+
+	caches := p.discord.Caches
+	vsUser, userOk := caches.VoiceState(guildID, member.User.ID)
+	vsBot, botOk := caches.VoiceState(guildID, p.discord.ApplicationID)
+	if botOk && vsBot.ChannelID != nil {
+		if !userOk || vsUser.ChannelID == nil || *vsUser.ChannelID != *vsBot.ChannelID {
+			return ErrWrongVoiceChannel
+		}
+	}
+
+	return nil
+}
@@ -5,7 +5,6 @@ import (
 	"log/slog"
 	"time"
 
-	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
 	"github.com/disgoorg/disgolink/v3/disgolink"
 	"github.com/disgoorg/disgolink/v3/lavalink"
@@ -21,6 +20,7 @@ func (p *Player) onVoiceServerUpdate(e *events.VoiceServerUpdate) {
 func (p *Player) onGuildVoiceStateUpdate(e *events.GuildVoiceStateUpdate) {
 	if e.VoiceState.UserID == e.Client().ApplicationID {
 		p.lavalink.OnVoiceStateUpdate(context.Background(), e.VoiceState.GuildID, e.VoiceState.ChannelID, e.VoiceState.SessionID)
+		p.unsuppress(e.VoiceState)
 	}
 }
 
@@ -29,18 +29,25 @@ func (p *Player) onTrackStart(lp disgolink.Player, e lavalink.TrackStartEvent) {
 	guildID := lp.GuildID()
 
 	queue, _ := p.Queue(ctx, guildID)
+	p.status(guildID, e.Track.Info.Title)
 
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	channelID := p.playingChannels[guildID]
-	msg, err := p.discord.Rest.CreateMessage(channelID, discord.MessageCreate{
-		Embeds:     Embeds("Now playing", false, e.Track),
-		Components: Components(len(queue) < 1),
-	})
+	s, ok := p.sessions[guildID]
+	if !ok {
+		p.logger.Warn("Failed to find the playing session", slog.Any("guild_id", guildID))
+		return
+	}
+
+	s.started = time.Now()
+	if p.Settings(guildID).Quiet {
+		return
+	}
 
-	if err == nil {
-		p.playingMessages[channelID] = msg.ID
+	err := s.post(e.Track, len(queue) < 1)
+	if err != nil {
+		p.logger.Warn("Failed to send playing message", slog.Any("channel_id", s.channelID), slog.Any("error", err))
 	}
 }
 
@@ -50,16 +57,21 @@ func (p *Player) onTrackEnd(lp disgolink.Player, e lavalink.TrackEndEvent) {
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	channelID := p.playingChannels[guildID]
-	messageID, ok := p.playingMessages[channelID]
+	s, ok := p.sessions[guildID]
 	if !ok {
-		p.logger.Warn("Failed to find the playing message", slog.Any("channel_id", channelID))
+		p.logger.Warn("Failed to find the playing session", slog.Any("guild_id", guildID))
 		return
 	}
 
-	err := p.discord.Rest.DeleteMessage(channelID, messageID)
+	// NOTE:
+	// Recording writes to disk, so it is done without holding up the player
+	go p.record(guildID, e.Track, e.Reason, s.started)
+	s.started = time.Time{}
+
+	messageID := s.messageID
+	err := s.remove()
 	if err != nil {
-		p.logger.Warn("Failed to delete playing message", slog.Any("channel_id", channelID), slog.Any("message_id", messageID))
+		p.logger.Warn("Failed to delete playing message", slog.Any("channel_id", s.channelID), slog.Any("message_id", messageID), slog.Any("error", err))
 	}
 }
 
@@ -71,6 +83,7 @@ func (p *Player) onTrackException(lp disgolink.Player, e lavalink.TrackException
 }
 
 func (p *Player) onQueueEnd(lp disgolink.Player, e lavaqueue.QueueEndEvent) {
+	p.status(e.GuildID(), "")
 	go func() {
 		time.Sleep(time.Second * 10)
 		track := lp.Track()
@@ -89,11 +102,9 @@ func (p *Player) onWebSocketClosed(lp disgolink.Player, e lavalink.WebSocketClos
 	defer p.m.Unlock()
 
 	guildID := lp.GuildID()
-	channelID := p.playingChannels[guildID]
-	messageID := p.playingMessages[channelID]
-
-	p.discord.Rest.DeleteMessage(channelID, messageID)
+	if s, ok := p.sessions[guildID]; ok {
+		s.remove()
+	}
 
-	delete(p.playingChannels, guildID)
-	delete(p.playingMessages, channelID)
+	delete(p.sessions, guildID)
 }
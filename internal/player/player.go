@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/Akvanvig/roboto-go/internal/config"
+	"github.com/Akvanvig/roboto-go/internal/store"
 	"github.com/disgoorg/json"
 	"golang.org/x/sync/errgroup"
 
@@ -22,18 +23,20 @@ import (
 )
 
 type TrackUserData struct {
-	User        string    `json:"username"`
-	UserIconURL string    `json:"icon_url"`
-	Timestamp   time.Time `json:"timestamp"`
+	UserID      snowflake.ID `json:"user_id"`
+	User        string       `json:"username"`
+	UserIconURL string       `json:"icon_url"`
+	Timestamp   time.Time    `json:"timestamp"`
 }
 
 type Player struct {
-	logger          *slog.Logger
-	cfg             *config.LavalinkConfig
-	discord         *bot.Client
-	lavalink        disgolink.Client
-	playingChannels map[snowflake.ID]snowflake.ID
-	playingMessages map[snowflake.ID]snowflake.ID
+	logger   *slog.Logger
+	cfg      *config.LavalinkConfig
+	discord  *bot.Client
+	lavalink disgolink.Client
+	settings *store.Store[snowflake.ID, Settings]
+	plays    *store.Log[Play]
+	sessions map[snowflake.ID]*session
 	// NOTE:
 	// This mutex is currently global, but it should be per guild
 	m sync.Mutex
@@ -43,11 +46,11 @@ func (p *Player) ChannelID(guildID snowflake.ID) *snowflake.ID {
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	channelID, ok := p.playingChannels[guildID]
+	s, ok := p.sessions[guildID]
 	if !ok {
 		return nil
 	}
-	return &channelID
+	return &s.channelID
 }
 
 // See https://github.com/CyberFlameGO/Lavalink-Client/tree/3ea412523817694cae8cc93ba2cc5f5c941f767c/src/main/java/lavalink/client/io/filters
@@ -161,8 +164,9 @@ func (p *Player) Add(ctx context.Context, guildID snowflake.ID, channelID snowfl
 	}
 
 	data, err := json.Marshal(TrackUserData{
+		UserID:      user.ID,
 		User:        user.Username,
-		UserIconURL: *user.AvatarURL(),
+		UserIconURL: user.EffectiveAvatarURL(),
 		Timestamp:   time.Now(),
 	})
 	if err != nil {
@@ -190,13 +194,17 @@ func (p *Player) Add(ctx context.Context, guildID snowflake.ID, channelID snowfl
 	// Track != nil -> Song is currently playing
 	// Track == nil -> Song has been added to queue
 	if track != nil {
-		p.playingChannels[guildID] = channelID
-	} else {
-		messageID := p.playingMessages[channelID]
-		_, err = p.discord.Rest.UpdateMessage(channelID, messageID, discord.MessageUpdate{
-			Components: new(Components(false)),
-		})
-		return err
+		settings := p.Settings(guildID)
+		if settings.AnnounceChannelID != 0 {
+			channelID = settings.AnnounceChannelID
+		}
+		p.sessions[guildID] = newSession(p.discord.Rest, channelID)
+
+		if settings.Volume > 0 {
+			return lp.Update(ctx, lavalink.WithVolume(settings.Volume))
+		}
+	} else if s, ok := p.sessions[guildID]; ok {
+		return s.update(false)
 	}
 
 	return nil
@@ -233,13 +241,11 @@ func (p *Player) Clear(ctx context.Context, guildID snowflake.ID) error {
 		return err
 	}
 
-	channelID := p.playingMessages[guildID]
-	messageID := p.playingMessages[channelID]
-	_, err = p.discord.Rest.UpdateMessage(channelID, messageID, discord.MessageUpdate{
-		Components: new(Components(true)),
-	})
+	if s, ok := p.sessions[guildID]; ok {
+		return s.update(true)
+	}
 
-	return err
+	return nil
 }
 
 func (p *Player) Skip(ctx context.Context, guildID snowflake.ID, count int) (*lavalink.Track, error) {
@@ -295,17 +301,23 @@ func (p *Player) Disconnect() {
 
 	// NOTE:
 	// We gracefully clean up sent messages to avoid user confusion.
-	for channelID, messageID := range p.playingMessages {
-		p.discord.Rest.DeleteMessage(channelID, messageID)
-		delete(p.playingMessages, channelID)
+	for guildID, s := range p.sessions {
+		s.remove()
+		delete(p.sessions, guildID)
 	}
+}
 
-	for guildID := range p.playingChannels {
-		delete(p.playingChannels, guildID)
+func New(discord *bot.Client, cfg *config.LavalinkConfig, storage *config.StorageConfig) (*Player, error) {
+	settings, err := store.Open[snowflake.ID, Settings](storage.Path, "music_settings")
+	if err != nil {
+		return nil, err
+	}
+
+	plays, err := store.OpenLog[Play](storage.Path, "music_plays")
+	if err != nil {
+		return nil, err
 	}
-}
 
-func New(discord *bot.Client, cfg *config.LavalinkConfig) *Player {
 	lavalink := disgolink.New(discord.ApplicationID,
 		disgolink.WithPlugins(
 			lavaqueue.New(),
@@ -313,12 +325,13 @@ func New(discord *bot.Client, cfg *config.LavalinkConfig) *Player {
 	)
 
 	player := &Player{
-		logger:          discord.Logger,
-		cfg:             cfg,
-		discord:         discord,
-		lavalink:        lavalink,
-		playingChannels: make(map[snowflake.ID]snowflake.ID),
-		playingMessages: make(map[snowflake.ID]snowflake.ID),
+		logger:   discord.Logger,
+		cfg:      cfg,
+		discord:  discord,
+		lavalink: lavalink,
+		settings: settings,
+		plays:    plays,
+		sessions: make(map[snowflake.ID]*session),
 	}
 
 	discord.AddEventListeners(
@@ -333,5 +346,5 @@ func New(discord *bot.Client, cfg *config.LavalinkConfig) *Player {
 		disgolink.NewListenerFunc(player.onWebSocketClosed),
 	)
 
-	return player
+	return player, nil
 }
@@ -0,0 +1,133 @@
+package player
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+var (
+	PermissionsVoice = []discord.Permissions{
+		discord.PermissionViewChannel,
+		discord.PermissionConnect,
+		discord.PermissionSpeak,
+	}
+	PermissionsStage = []discord.Permissions{
+		discord.PermissionViewChannel,
+		discord.PermissionConnect,
+	}
+	PermissionsText = []discord.Permissions{
+		discord.PermissionViewChannel,
+		discord.PermissionSendMessages,
+		discord.PermissionEmbedLinks,
+	}
+	PermissionsThread = []discord.Permissions{
+		discord.PermissionViewChannel,
+		discord.PermissionSendMessagesInThreads,
+		discord.PermissionEmbedLinks,
+	}
+)
+
+func missing(perms discord.Permissions, required []discord.Permissions) []string {
+	names := make([]string, 0, len(required))
+	for _, perm := range required {
+		if perms.Missing(perm) {
+			names = append(names, fmt.Sprintf("**%s**", perm))
+		}
+	}
+	return names
+}
+
+func missingError(names []string, channel discord.GuildChannel) error {
+	if len(names) == 1 {
+		return fmt.Errorf("missing the %s permission in %s", names[0], channel.Mention())
+	}
+	return fmt.Errorf("missing the %s permissions in %s", strings.Join(names, ", "), channel.Mention())
+}
+
+// Looks up the text channel, threads the bot hasn't joined are not cached and are fetched instead
+func (p *Player) textChannel(channelID snowflake.ID) (discord.GuildChannel, bool) {
+	if channel, ok := p.discord.Caches.Channel(channelID); ok {
+		return channel, true
+	}
+
+	channel, err := p.discord.Rest.GetChannel(channelID)
+	if err != nil {
+		return nil, false
+	}
+	guildChannel, ok := channel.(discord.GuildChannel)
+	return guildChannel, ok
+}
+
+// Checks that the bot is able to join the voice channel and post in the text channel.
+// The returned error explains what is missing, and is meant to be shown to the user.
+func (p *Player) Preflight(guildID snowflake.ID, voiceChannelID snowflake.ID, textChannelID snowflake.ID) error {
+	caches := p.discord.Caches
+
+	member, ok := caches.SelfMember(guildID)
+	if !ok {
+		return errors.New("failed to look up the bot permissions in this server, try again in a moment")
+	}
+
+	var errs error
+
+	// NOTE:
+	// If we are already connected to the channel, joining it is a no-op
+	vsBot, ok := caches.VoiceState(guildID, p.discord.ApplicationID)
+	if !ok || vsBot.ChannelID == nil || *vsBot.ChannelID != voiceChannelID {
+		voice, ok := caches.Channel(voiceChannelID)
+		if !ok {
+			errs = errors.Join(errs, errors.New("failed to find your voice channel, try rejoining it"))
+		} else {
+			perms := caches.MemberPermissionsInChannel(voice, member)
+
+			required := PermissionsVoice
+			if voice.Type() == discord.ChannelTypeGuildStageVoice {
+				required = PermissionsStage
+			}
+			if names := missing(perms, required); len(names) > 0 {
+				errs = errors.Join(errs, missingError(names, voice))
+			}
+
+			// NOTE:
+			// Members that can move members are allowed to join full channels
+			if vc, ok := voice.(discord.GuildVoiceChannel); ok && vc.UserLimit > 0 && perms.Missing(discord.PermissionMoveMembers) {
+				users := 0
+				for vs := range caches.VoiceStates(guildID) {
+					if vs.ChannelID != nil && *vs.ChannelID == voiceChannelID {
+						users++
+					}
+				}
+				if users >= vc.UserLimit {
+					errs = errors.Join(errs, fmt.Errorf("%s is full, ask someone to make room or raise the user limit", voice.Mention()))
+				}
+			}
+		}
+	}
+
+	text, ok := p.textChannel(textChannelID)
+	if !ok {
+		return errors.Join(errs, errors.New("failed to find this text channel"))
+	}
+
+	// NOTE:
+	// Threads have no permission overwrites of their own, they follow the parent channel
+	required, permsChannel := PermissionsText, text
+	if thread, ok := text.(discord.GuildThread); ok {
+		parent, ok := caches.Channel(*thread.ParentID())
+		if !ok {
+			return errors.Join(errs, errors.New("failed to find the parent channel of this thread"))
+		}
+		required, permsChannel = PermissionsThread, parent
+	}
+
+	perms := caches.MemberPermissionsInChannel(permsChannel, member)
+	if names := missing(perms, required); len(names) > 0 {
+		errs = errors.Join(errs, missingError(names, text))
+	}
+
+	return errs
+}
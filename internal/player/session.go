@@ -0,0 +1,99 @@
+package player
+
+import (
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/disgolink/v3/lavalink"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// The subset of the rest client a session needs to manage its message
+type sessionClient interface {
+	CreateMessage(channelID snowflake.ID, messageCreate discord.MessageCreate, opts ...rest.RequestOpt) (*discord.Message, error)
+	UpdateMessage(channelID snowflake.ID, messageID snowflake.ID, messageUpdate discord.MessageUpdate, opts ...rest.RequestOpt) (*discord.Message, error)
+	DeleteMessage(channelID snowflake.ID, messageID snowflake.ID, opts ...rest.RequestOpt) error
+}
+
+// A session holds the text channel music was requested from in a guild,
+// and the "Now playing" message posted there.
+//
+// NOTE:
+// Sessions are not safe for concurrent use, callers must hold the player mutex
+type session struct {
+	client     sessionClient
+	channelID  snowflake.ID
+	messageID  snowflake.ID // zero when no message is posted
+	track      lavalink.Track
+	started    time.Time // when the current track started playing
+	queueEmpty bool
+}
+
+func newSession(client sessionClient, channelID snowflake.ID) *session {
+	return &session{
+		client:     client,
+		channelID:  channelID,
+		queueEmpty: true,
+	}
+}
+
+func (s *session) posted() bool {
+	return s.messageID != 0
+}
+
+// Posts a new playing message for the track, replacing the current one if any
+func (s *session) post(track lavalink.Track, queueEmpty bool) error {
+	err := s.remove()
+	if err != nil {
+		return err
+	}
+
+	s.track = track
+	s.queueEmpty = queueEmpty
+
+	msg, err := s.client.CreateMessage(s.channelID, discord.MessageCreate{
+		Embeds:     Embeds("Now playing", false, track),
+		Components: Components(queueEmpty),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.messageID = msg.ID
+	return nil
+}
+
+// Updates the buttons of the playing message.
+// If the message has been deleted by someone else, a new one is posted.
+func (s *session) update(queueEmpty bool) error {
+	s.queueEmpty = queueEmpty
+	if !s.posted() {
+		return nil
+	}
+
+	_, err := s.client.UpdateMessage(s.channelID, s.messageID, discord.MessageUpdate{
+		Components: new(Components(queueEmpty)),
+	})
+	if rest.IsJSONErrorCode(err, rest.JSONErrorCodeUnknownMessage) {
+		s.messageID = 0
+		return s.post(s.track, queueEmpty)
+	}
+
+	return err
+}
+
+// Deletes the playing message. A message that is already gone is not an error.
+func (s *session) remove() error {
+	if !s.posted() {
+		return nil
+	}
+
+	err := s.client.DeleteMessage(s.channelID, s.messageID)
+	s.messageID = 0
+	if rest.IsJSONErrorCode(err, rest.JSONErrorCodeUnknownMessage) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,283 @@
+package player
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/disgolink/v3/lavalink"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const testChannelID = snowflake.ID(100)
+
+// A fake channel keeping the messages in memory
+type fakeSessionClient struct {
+	nextID   snowflake.ID
+	messages map[snowflake.ID]discord.MessageCreate
+	calls    []string
+}
+
+func newFakeSessionClient() *fakeSessionClient {
+	return &fakeSessionClient{
+		nextID:   1,
+		messages: make(map[snowflake.ID]discord.MessageCreate),
+	}
+}
+
+func unknownMessage() error {
+	return &rest.Error{Code: rest.JSONErrorCodeUnknownMessage, Message: "Unknown Message"}
+}
+
+func (c *fakeSessionClient) CreateMessage(channelID snowflake.ID, messageCreate discord.MessageCreate, opts ...rest.RequestOpt) (*discord.Message, error) {
+	c.calls = append(c.calls, "create")
+	id := c.nextID
+	c.nextID++
+	c.messages[id] = messageCreate
+	return &discord.Message{ID: id, ChannelID: channelID}, nil
+}
+
+func (c *fakeSessionClient) UpdateMessage(channelID snowflake.ID, messageID snowflake.ID, messageUpdate discord.MessageUpdate, opts ...rest.RequestOpt) (*discord.Message, error) {
+	c.calls = append(c.calls, "update")
+	msg, ok := c.messages[messageID]
+	if !ok {
+		return nil, unknownMessage()
+	}
+	if messageUpdate.Components != nil {
+		msg.Components = *messageUpdate.Components
+	}
+	c.messages[messageID] = msg
+	return &discord.Message{ID: messageID, ChannelID: channelID}, nil
+}
+
+func (c *fakeSessionClient) DeleteMessage(channelID snowflake.ID, messageID snowflake.ID, opts ...rest.RequestOpt) error {
+	c.calls = append(c.calls, "delete")
+	if _, ok := c.messages[messageID]; !ok {
+		return unknownMessage()
+	}
+	delete(c.messages, messageID)
+	return nil
+}
+
+func testTrack(title string) lavalink.Track {
+	return lavalink.Track{Info: lavalink.TrackInfo{Title: title}}
+}
+
+// Whether the skip button of the message is disabled, meaning the queue is empty
+func skipDisabled(t *testing.T, msg discord.MessageCreate) bool {
+	t.Helper()
+	row, ok := msg.Components[0].(discord.ActionRowComponent)
+	if !ok {
+		t.Fatalf("expected an action row, got %T", msg.Components[0])
+	}
+	return row.Components[0].(discord.ButtonComponent).Disabled
+}
+
+func expectCalls(t *testing.T, c *fakeSessionClient, calls ...string) {
+	t.Helper()
+	if !slices.Equal(c.calls, calls) {
+		t.Fatalf("expected calls %v, got %v", calls, c.calls)
+	}
+	c.calls = nil
+}
+
+func TestSessionPost(t *testing.T) {
+	c := newFakeSessionClient()
+	s := newSession(c, testChannelID)
+
+	if s.posted() {
+		t.Fatal("new session should have no message")
+	}
+
+	err := s.post(testTrack("first"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "create")
+	if !s.posted() || len(c.messages) != 1 {
+		t.Fatalf("expected one posted message, got %d", len(c.messages))
+	}
+	if skipDisabled(t, c.messages[s.messageID]) {
+		t.Fatal("skip should be enabled with a queue")
+	}
+
+	// NOTE:
+	// The next track replaces the message of the previous one
+	first := s.messageID
+	err = s.post(testTrack("second"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "delete", "create")
+	if _, ok := c.messages[first]; ok {
+		t.Fatal("previous message should be deleted")
+	}
+	if len(c.messages) != 1 || s.track.Info.Title != "second" {
+		t.Fatalf("expected only the message of the second track, got %d messages", len(c.messages))
+	}
+}
+
+func TestSessionUpdate(t *testing.T) {
+	c := newFakeSessionClient()
+	s := newSession(c, testChannelID)
+
+	// Nothing posted yet, only the state is kept
+	err := s.update(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c)
+	if s.queueEmpty {
+		t.Fatal("queue state should be kept without a message")
+	}
+
+	err = s.post(testTrack("first"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "create")
+	if !skipDisabled(t, c.messages[s.messageID]) {
+		t.Fatal("skip should be disabled with an empty queue")
+	}
+
+	err = s.update(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "update")
+	if skipDisabled(t, c.messages[s.messageID]) {
+		t.Fatal("skip should be enabled after queueing")
+	}
+}
+
+func TestSessionRemove(t *testing.T) {
+	c := newFakeSessionClient()
+	s := newSession(c, testChannelID)
+
+	// Removing without a message is a no-op
+	err := s.remove()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c)
+
+	err = s.post(testTrack("first"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "create")
+
+	err = s.remove()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "delete")
+	if s.posted() || len(c.messages) != 0 {
+		t.Fatal("message should be deleted")
+	}
+}
+
+func TestSessionIdle(t *testing.T) {
+	c := newFakeSessionClient()
+	s := newSession(c, testChannelID)
+
+	err := s.post(testTrack("first"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "create")
+
+	// NOTE:
+	// A track ending removes the message, while idle the queue changes touch nothing
+	err = s.remove()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "delete")
+
+	err = s.update(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c)
+	if s.posted() {
+		t.Fatal("an idle session should not post a message")
+	}
+
+	// The next track playing posts a message again
+	err = s.post(testTrack("second"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "create")
+}
+
+func TestSessionStop(t *testing.T) {
+	c := newFakeSessionClient()
+	s := newSession(c, testChannelID)
+
+	err := s.post(testTrack("first"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "create")
+
+	// Stopping clears the queue and then removes the message
+	err = s.update(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "update")
+
+	err = s.remove()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "delete")
+
+	err = s.remove()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c)
+}
+
+func TestSessionDeletedByModerator(t *testing.T) {
+	c := newFakeSessionClient()
+	s := newSession(c, testChannelID)
+
+	err := s.post(testTrack("first"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "create")
+
+	deleted := s.messageID
+	delete(c.messages, deleted)
+
+	// NOTE:
+	// Updating a deleted message fails with Unknown Message, and a new one is posted instead
+	err = s.update(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "update", "create")
+	if !s.posted() || s.messageID == deleted {
+		t.Fatal("a new message should be posted")
+	}
+	if s.track.Info.Title != "first" || skipDisabled(t, c.messages[s.messageID]) {
+		t.Fatal("the new message should show the current track and queue")
+	}
+
+	// Removing a message that is already gone is not an error
+	delete(c.messages, s.messageID)
+	err = s.remove()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, c, "delete")
+	if s.posted() {
+		t.Fatal("session should forget the deleted message")
+	}
+}
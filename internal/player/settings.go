@@ -0,0 +1,27 @@
+package player
+
+import (
+	"github.com/disgoorg/disgolink/v3/lavalink"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// Per-guild music settings. The zero value is the default behaviour.
+type Settings struct {
+	Volume            int                 `json:"volume,omitempty"`              // 0 keeps the lavalink default
+	AnnounceChannelID snowflake.ID        `json:"announce_channel_id,omitempty"` // 0 announces in the command channel
+	Quiet             bool                `json:"quiet,omitempty"`               // skip the "Now playing" message for each track
+	Source            lavalink.SearchType `json:"source,omitempty"`              // empty uses YouTube
+	DJRoleID          snowflake.ID        `json:"dj_role_id,omitempty"`          // 0 lets everyone control the player
+}
+
+func (p *Player) Settings(guildID snowflake.ID) Settings {
+	settings, _ := p.settings.Get(guildID)
+	return settings
+}
+
+func (p *Player) SetSettings(guildID snowflake.ID, settings Settings) error {
+	if settings == (Settings{}) {
+		return p.settings.Delete(guildID)
+	}
+	return p.settings.Set(guildID, settings)
+}
@@ -0,0 +1,182 @@
+package player
+
+import (
+	"cmp"
+	"encoding/csv"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/disgoorg/disgolink/v3/lavalink"
+	"github.com/disgoorg/json"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// A Play is a track that was listened to until it ended or got skipped
+type Play struct {
+	GuildID    snowflake.ID  `json:"guild_id"`
+	UserID     snowflake.ID  `json:"user_id"`
+	Username   string        `json:"username"`
+	Identifier string        `json:"identifier"`
+	Title      string        `json:"title"`
+	Source     string        `json:"source"`
+	Listened   time.Duration `json:"listened"`
+	EndedAt    time.Time     `json:"ended_at"`
+}
+
+type StatsFilter struct {
+	GuildID snowflake.ID // 0 matches every guild
+	UserID  snowflake.ID // 0 matches every user
+	Since   time.Time    // zero matches all time
+}
+
+func (f StatsFilter) match(play Play) bool {
+	if f.GuildID != 0 && play.GuildID != f.GuildID {
+		return false
+	}
+	if f.UserID != 0 && play.UserID != f.UserID {
+		return false
+	}
+	return play.EndedAt.After(f.Since)
+}
+
+type TrackStats struct {
+	Identifier string
+	Title      string
+	Plays      int
+}
+
+type UserStats struct {
+	UserID   snowflake.ID
+	Username string
+	Plays    int
+	Listened time.Duration
+}
+
+type Stats struct {
+	Plays     int
+	Listened  time.Duration
+	TopTracks []TrackStats
+	TopUsers  []UserStats
+}
+
+// Records a finished track, ignoring tracks that never got to play
+func (p *Player) record(guildID snowflake.ID, track lavalink.Track, reason lavalink.TrackEndReason, started time.Time) {
+	if reason == lavalink.TrackEndReasonLoadFailed || started.IsZero() {
+		return
+	}
+
+	listened := time.Since(started)
+	if !track.Info.IsStream {
+		listened = min(listened, time.Duration(track.Info.Length.Milliseconds())*time.Millisecond)
+	}
+
+	var data TrackUserData
+	json.Unmarshal(track.UserData, &data)
+
+	err := p.plays.Append(Play{
+		GuildID:    guildID,
+		UserID:     data.UserID,
+		Username:   data.User,
+		Identifier: track.Info.Identifier,
+		Title:      track.Info.Title,
+		Source:     track.Info.SourceName,
+		Listened:   listened,
+		EndedAt:    time.Now(),
+	})
+	if err != nil {
+		p.logger.Warn("Failed to record play", slog.Any("guild_id", guildID), slog.Any("error", err))
+	}
+}
+
+// Stats aggregates the recorded plays matching the filter, keeping the top n tracks and users
+func (p *Player) Stats(filter StatsFilter, n int) (Stats, error) {
+	var stats Stats
+	tracks := make(map[string]*TrackStats)
+	users := make(map[snowflake.ID]*UserStats)
+
+	err := p.plays.Scan(func(play Play) bool {
+		if !filter.match(play) {
+			return true
+		}
+
+		stats.Plays++
+		stats.Listened += play.Listened
+
+		track, ok := tracks[play.Identifier]
+		if !ok {
+			track = &TrackStats{
+				Identifier: play.Identifier,
+			}
+			tracks[play.Identifier] = track
+		}
+		track.Title = play.Title
+		track.Plays++
+
+		user, ok := users[play.UserID]
+		if !ok {
+			user = &UserStats{
+				UserID: play.UserID,
+			}
+			users[play.UserID] = user
+		}
+		user.Username = play.Username
+		user.Plays++
+		user.Listened += play.Listened
+
+		return true
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	for _, track := range tracks {
+		stats.TopTracks = append(stats.TopTracks, *track)
+	}
+	slices.SortFunc(stats.TopTracks, func(a, b TrackStats) int {
+		return cmp.Or(cmp.Compare(b.Plays, a.Plays), cmp.Compare(a.Title, b.Title))
+	})
+	stats.TopTracks = stats.TopTracks[:min(n, len(stats.TopTracks))]
+
+	for _, user := range users {
+		stats.TopUsers = append(stats.TopUsers, *user)
+	}
+	slices.SortFunc(stats.TopUsers, func(a, b UserStats) int {
+		return cmp.Or(cmp.Compare(b.Plays, a.Plays), cmp.Compare(a.Username, b.Username))
+	})
+	stats.TopUsers = stats.TopUsers[:min(n, len(stats.TopUsers))]
+
+	return stats, nil
+}
+
+// ExportStats writes the recorded plays matching the filter as CSV
+func (p *Player) ExportStats(filter StatsFilter, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"ended_at", "guild_id", "user_id", "username", "identifier", "title", "source", "listened_seconds"})
+
+	err := p.plays.Scan(func(play Play) bool {
+		if !filter.match(play) {
+			return true
+		}
+
+		writer.Write([]string{
+			play.EndedAt.UTC().Format(time.RFC3339),
+			play.GuildID.String(),
+			play.UserID.String(),
+			play.Username,
+			play.Identifier,
+			play.Title,
+			play.Source,
+			strconv.FormatFloat(play.Listened.Seconds(), 'f', 0, 64),
+		})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,134 @@
+package player
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/omit"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// NOTE:
+// Disgo does not wrap the voice channel status endpoint yet
+var EndpointUpdateVoiceChannelStatus = rest.NewEndpoint(http.MethodPut, "/channels/{channel.id}/voice-status")
+
+const (
+	maxStageTopicLength   = 120
+	maxVoiceStatusLength  = 500
+	voiceStatusTruncation = "…"
+)
+
+type voiceChannelStatusUpdate struct {
+	Status string `json:"status"`
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-1]) + voiceStatusTruncation
+}
+
+// Lets the bot speak when it has joined a stage channel as a suppressed audience member
+func (p *Player) unsuppress(vs discord.VoiceState) {
+	if vs.ChannelID == nil || !vs.Suppress || vs.RequestToSpeakTimestamp != nil {
+		return
+	}
+
+	caches := p.discord.Caches
+	channel, ok := caches.Channel(*vs.ChannelID)
+	if !ok || channel.Type() != discord.ChannelTypeGuildStageVoice {
+		return
+	}
+
+	member, ok := caches.SelfMember(vs.GuildID)
+	if !ok {
+		p.logger.Warn("Failed to find bot member for stage channel", slog.Any("guild_id", vs.GuildID))
+		return
+	}
+
+	update := discord.CurrentUserVoiceStateUpdate{
+		ChannelID: vs.ChannelID,
+	}
+
+	// NOTE:
+	// Stage moderators can invite themselves to speak directly,
+	// everyone else has to raise their hand
+	perms := caches.MemberPermissionsInChannel(channel, member)
+	switch {
+	case perms.Has(discord.PermissionMuteMembers):
+		update.Suppress = new(false)
+	case perms.Has(discord.PermissionRequestToSpeak):
+		update.RequestToSpeakTimestamp = omit.NewPtr(time.Now())
+	default:
+		p.logger.Warn("Missing permissions to speak in stage channel", slog.Any("channel_id", *vs.ChannelID))
+		return
+	}
+
+	err := p.discord.Rest.UpdateCurrentUserVoiceState(vs.GuildID, update)
+	if err != nil {
+		p.logger.Warn("Failed to update stage voice state", slog.Any("channel_id", *vs.ChannelID), slog.Any("error", err))
+	}
+}
+
+func (p *Player) stageLive(guildID snowflake.ID, channelID snowflake.ID) bool {
+	for stage := range p.discord.Caches.StageInstances(guildID) {
+		if stage.ChannelID == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets the stage topic or voice channel status of the bot's current voice channel
+func (p *Player) status(guildID snowflake.ID, status string) {
+	if !p.cfg.TrackStatus {
+		return
+	}
+
+	caches := p.discord.Caches
+	vs, ok := caches.VoiceState(guildID, p.discord.ApplicationID)
+	if !ok || vs.ChannelID == nil {
+		return
+	}
+
+	channel, ok := caches.Channel(*vs.ChannelID)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch channel.Type() {
+	case discord.ChannelTypeGuildStageVoice:
+		// NOTE:
+		// Removing the topic would end the stage for everyone listening,
+		// so we simply leave the last one in place
+		if status == "" {
+			return
+		}
+
+		topic := truncate(status, maxStageTopicLength)
+		if p.stageLive(guildID, channel.ID()) {
+			_, err = p.discord.Rest.UpdateStageInstance(channel.ID(), discord.StageInstanceUpdate{
+				Topic: &topic,
+			})
+		} else {
+			_, err = p.discord.Rest.CreateStageInstance(discord.StageInstanceCreate{
+				ChannelID: channel.ID(),
+				Topic:     topic,
+			})
+		}
+	case discord.ChannelTypeGuildVoice:
+		err = p.discord.Rest.Do(EndpointUpdateVoiceChannelStatus.Compile(nil, channel.ID()), voiceChannelStatusUpdate{
+			Status: truncate(status, maxVoiceStatusLength),
+		}, nil)
+	}
+
+	if err != nil {
+		p.logger.Warn("Failed to update voice channel status", slog.Any("channel_id", channel.ID()), slog.Any("error", err))
+	}
+}
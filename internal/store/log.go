@@ -0,0 +1,93 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A Log is an append-only list of records persisted as a JSON lines file.
+// Unlike a Store it never rewrites the file, so it suits records that keep growing.
+type Log[T any] struct {
+	path string
+	m    sync.Mutex // serializes appends
+}
+
+func (l *Log[T]) Append(record T) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Scan calls fn for every record in the order they were appended.
+// Scanning stops early when fn returns false.
+//
+// NOTE:
+// Scanning doesn't block appending, a record appended meanwhile may or may not be scanned
+func (l *Log[T]) Scan(fn func(record T) bool) error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// NOTE:
+		// A crash mid-write can leave a partial line behind,
+		// which is skipped rather than failing the whole scan
+		var record T
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			continue
+		}
+
+		if !fn(record) {
+			return nil
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("scan %s: %w", l.path, err)
+	}
+
+	return nil
+}
+
+// OpenLog prepares the named log in dir, creating the directory if needed
+func OpenLog[T any](dir string, name string) (*Log[T], error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Log[T]{
+		path: filepath.Join(dir, name+".jsonl"),
+	}, nil
+}
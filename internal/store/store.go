@@ -0,0 +1,159 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A Store is a key-value map persisted as a JSON file.
+// Every write replaces the file atomically, so it is meant for small
+// amounts of data like per-guild settings.
+type Store[K comparable, V any] struct {
+	path string
+	data map[K]V
+	m    sync.RWMutex
+}
+
+// Writes data to path through a temporary file to avoid partial writes
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Saves the changed copy of the data, which only replaces the data in memory once it is on disk
+func (s *Store[K, V]) save(data map[K]V) error {
+	file, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = writeFile(s.path, file)
+	if err != nil {
+		return fmt.Errorf("save %s: %w", s.path, err)
+	}
+
+	s.data = data
+	return nil
+}
+
+func (s *Store[K, V]) Get(key K) (V, bool) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	value, ok := s.data[key]
+	return value, ok
+}
+
+func (s *Store[K, V]) Set(key K, value V) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	data := maps.Clone(s.data)
+	data[key] = value
+	return s.save(data)
+}
+
+// Update atomically modifies the value of a key
+func (s *Store[K, V]) Update(key K, update func(value V, ok bool) V) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	value, ok := s.data[key]
+	data := maps.Clone(s.data)
+	data[key] = update(value, ok)
+	return s.save(data)
+}
+
+// Modify atomically modifies the value of a key, deleting the key if keep is false
+func (s *Store[K, V]) Modify(key K, modify func(value V, ok bool) (V, bool)) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	value, ok := s.data[key]
+	value, keep := modify(value, ok)
+	if !keep && !ok {
+		return nil
+	}
+
+	data := maps.Clone(s.data)
+	if keep {
+		data[key] = value
+	} else {
+		delete(data, key)
+	}
+	return s.save(data)
+}
+
+func (s *Store[K, V]) Delete(key K) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.data[key]; !ok {
+		return nil
+	}
+
+	data := maps.Clone(s.data)
+	delete(data, key)
+	return s.save(data)
+}
+
+// All returns a copy of every entry in the store
+func (s *Store[K, V]) All() map[K]V {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	return maps.Clone(s.data)
+}
+
+// Open reads the named store in dir, creating the directory if needed
+func Open[K comparable, V any](dir string, name string) (*Store[K, V], error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store[K, V]{
+		path: filepath.Join(dir, name+".json"),
+		data: make(map[K]V),
+	}
+
+	file, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(file, &s.data)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", s.path, err)
+	}
+	if s.data == nil {
+		s.data = make(map[K]V)
+	}
+
+	return s, nil
+}